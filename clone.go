@@ -46,6 +46,11 @@ type cloneOptions struct {
 	accessKey           string
 	secretKey           string
 	endpoint            string
+	provider            string
+	source              string
+	encryptionKey       string
+	remoteName          string
+	limits              *config.LimitsObject
 	progressDownloading func(maxTicks int64)
 	progressProcessing  func(maxTicks int64)
 }
@@ -80,6 +85,43 @@ func CloneOptionSetEndpoint(endpoint string) func(optns *cloneOptions) {
 	}
 }
 
+// Get the credentials from a provider ("static", "env", "shared", "process" or "keyring"), see
+// RemoteOptionSetCredentialProvider. Defaults to the keyring when keys are given, otherwise to "env".
+func CloneOptionSetCredentialProvider(provider, source string) func(optns *cloneOptions) {
+	return func(optns *cloneOptions) {
+		optns.provider = provider
+		optns.source = source
+	}
+}
+
+// Name for the remote that is cloned from (defaults to "primary")
+func CloneOptionSetRemoteName(remoteName string) func(optns *cloneOptions) {
+	return func(optns *cloneOptions) {
+		optns.remoteName = remoteName
+	}
+}
+
+// Decrypt objects from the remote with the given (hex encoded) key
+func CloneOptionSetEncryptionKey(encryptionKey string) func(optns *cloneOptions) {
+	return func(optns *cloneOptions) {
+		optns.encryptionKey = encryptionKey
+	}
+}
+
+// Limit the bandwidth and request rate for this clone (the limits are not saved for the remote)
+func CloneOptionSetLimits(bytesPerSecond int64, requestsPerSecond float64) func(optns *cloneOptions) {
+	return func(optns *cloneOptions) {
+		optns.limits = optns.limits.Override(&config.LimitsObject{BytesPerSecond: bytesPerSecond, RequestsPerSecond: requestsPerSecond})
+	}
+}
+
+// Number of tree objects to download in parallel (defaults to 16)
+func CloneOptionSetConcurrency(concurrency int) func(optns *cloneOptions) {
+	return func(optns *cloneOptions) {
+		optns.limits = optns.limits.Override(&config.LimitsObject{Concurrency: concurrency})
+	}
+}
+
 func CloneOptionSetDownloadProgress(progressDownloading func(maxTicks int64)) func(optns *cloneOptions) {
 	return func(optns *cloneOptions) {
 		optns.progressDownloading = progressDownloading
@@ -97,22 +139,29 @@ type CloneOptions func(*cloneOptions)
 // Clone a remote repository
 func Clone(url, path string, options ...CloneOptions) (*Repository, error) {
 
-	optns := &cloneOptions{}
+	optns := &cloneOptions{remoteName: "primary"}
 	for _, op := range options {
 		op(optns)
 	}
 
-	err := config.SaveConfigFromUrl(url, path, optns.accessKey, optns.secretKey, optns.endpoint, optns.leafSize, optns.maxRepoSize)
+	err := config.SaveConfigFromUrl(optns.remoteName, url, path, optns.accessKey, optns.secretKey, optns.endpoint, optns.provider, optns.source, optns.leafSize, optns.maxRepoSize)
 	if err != nil {
 		return nil, err
 	}
 
+	if optns.encryptionKey != "" {
+		err = config.SetEncryptionKey(optns.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	repo, err := OpenRepository(path)
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := backend.GetDefaultClient()
+	client, err := backend.GetClientWithLimits(optns.remoteName, optns.limits)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +176,7 @@ func Clone(url, path string, options ...CloneOptions) (*Repository, error) {
 		optns.progressProcessing = progressDummy
 	}
 
-	err = clone(client, optns.progressDownloading, optns.progressProcessing)
+	err = clone(client, optns.limits.GetConcurrency(treeDownloaders), optns.progressDownloading, optns.progressProcessing)
 	if err != nil {
 		return nil, err
 	}
@@ -137,14 +186,26 @@ func Clone(url, path string, options ...CloneOptions) (*Repository, error) {
 
 func treeDownloader(trees <-chan treeInput, results chan<- treeOutput, errs chan<- error) {
 
+	// Keep on draining the trees after an error so the sender does not block
+	failed := false
 	for t := range trees {
+		if failed {
+			continue
+		}
+
 		// Pull down tree object
-		cas.PullBlobDownToLocalDisk(t.hash, kv.TREE, t.client)
+		_, err := cas.PullBlobDownToLocalDisk(t.hash, kv.TREE, t.client)
+		if err != nil {
+			errs <- fmt.Errorf("Failed to pull tree object %s: %v", t.hash, err)
+			failed = true
+			continue
+		}
 
 		to, err := core.GetTreeObject(t.hash)
 		if err != nil {
-			errs <- fmt.Errorf("core.GetTreeObject", err)
-			return
+			errs <- fmt.Errorf("core.GetTreeObject error: %v", err)
+			failed = true
+			continue
 		}
 
 		results <- treeOutput{added: to.S3gitAdded}
@@ -152,13 +213,15 @@ func treeDownloader(trees <-chan treeInput, results chan<- treeOutput, errs chan
 		// Delete the chunks for the tree object since we are unlikely the need it again
 		err = cas.DeleteLeavesForBlob(t.hash)
 		if err != nil {
-			errs <- fmt.Errorf("DeleteChunksForBlob error: ", err)
-			return
+			errs <- fmt.Errorf("DeleteChunksForBlob error: %v", err)
+			failed = true
 		}
 	}
 }
 
-func clone(client backend.Backend, progressDownloading, progressProcessing func(maxTicks int64)) error {
+const treeDownloaders = 16
+
+func clone(client backend.Backend, concurrency int, progressDownloading, progressProcessing func(maxTicks int64)) error {
 
 	// Get map of prefixes already in store
 	prefixesInBackend, err := listPrefixes(client)
@@ -172,11 +235,11 @@ func clone(client backend.Backend, progressDownloading, progressProcessing func(
 	var wg sync.WaitGroup
 	trees := make(chan treeInput)
 	results := make(chan treeOutput)
-	// TODO: Handle error(s) read from error channel
-	errs := make(chan error)
+	// Every downloader and the sender report at most one error
+	errs := make(chan error, concurrency+1)
 
 	// Start multiple downloaders in parallel
-	for i := 0; i <= 16; i++ {
+	for i := 0; i < concurrency; i++ {
 
 		wg.Add(1)
 		go func() {
@@ -188,30 +251,41 @@ func clone(client backend.Backend, progressDownloading, progressProcessing func(
 	// Push trees onto input channel
 	go func() {
 
+		// Close input channel
+		defer close(trees)
+
 		progressDownloading(int64(len(prefixesInBackend)))
 
 		for prefix, _ := range prefixesInBackend {
 
 			// TODO: Make resistant to crashes/interrupts, e.g. first save blobs, then trees, then commits, and finally prefix objects
-			cas.PullBlobDownToLocalDisk(prefix, kv.PREFIX, client)
+			_, err := cas.PullBlobDownToLocalDisk(prefix, kv.PREFIX, client)
+			if err != nil {
+				errs <- fmt.Errorf("Failed to pull prefix object %s: %v", prefix, err)
+				return
+			}
 			po, err := core.GetPrefixObject(prefix)
 			if err != nil {
-				errs <- fmt.Errorf("core.GetCommitObject error: ", err)
+				errs <- fmt.Errorf("core.GetPrefixObject error: %v", err)
 				return
 			}
 
 			// Now pull down commit object
-			cas.PullBlobDownToLocalDisk(po.S3gitFollowMe, kv.COMMIT, client)
+			_, err = cas.PullBlobDownToLocalDisk(po.S3gitFollowMe, kv.COMMIT, client)
+			if err != nil {
+				errs <- fmt.Errorf("Failed to pull commit object %s: %v", po.S3gitFollowMe, err)
+				return
+			}
 			co, err := core.GetCommitObject(po.S3gitFollowMe)
 			if err != nil {
-				errs <- fmt.Errorf("core.GetCommitObject error: ", err)
+				errs <- fmt.Errorf("core.GetCommitObject error: %v", err)
 				return
 			}
 
 			// Mark warm and cold parents as parents
 			err = co.MarkWarmAndColdParents()
 			if err != nil {
-				errs <- fmt.Errorf("co.MarkWarmAndColdParents error: ", err)
+				errs <- fmt.Errorf("co.MarkWarmAndColdParents error: %v", err)
 				return
 			}
 
@@ -222,15 +296,13 @@ func clone(client backend.Backend, progressDownloading, progressProcessing func(
 			if co.S3gitSnapshot != "" {
 				err = cacheKeysInKV([]string{co.S3gitSnapshot}, kv.SNAPSHOT)
 				if err != nil {
+					errs <- err
 					return
 				}
 			}
 
 			progressDownloading(int64(len(prefixesInBackend)))
 		}
-
-		// Close input channel
-		close(trees)
 	}()
 
 	// Wait for workers to complete
@@ -240,11 +312,21 @@ func clone(client backend.Backend, progressDownloading, progressProcessing func(
 	}()
 
 	for r := range results {
-		// Cache root hash for all added blobs in this commit ...
-		err := cacheKeyForBlobsToLocalDiskFirst(r.added)
 		if err != nil {
-			return err
+			continue // Drain remaining results
 		}
+		// Cache root hash for all added blobs in this commit ...
+		err = cacheKeyForBlobsToLocalDiskFirst(r.added)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Report the first error of the downloaders (if any)
+	select {
+	case err := <-errs:
+		return err
+	default:
 	}
 
 	// As last step first stop the keys and import sorted list into KV
@@ -180,10 +180,100 @@ func (c *Client) VerifyHash(hash string) (bool, error) {
 	return lst.Count != 0, nil
 }
 
+// Get the size of a file in Amazon Cloud Drive
+func (c *Client) Stat(hash string) (int64, bool, error) {
+
+	lst, err := c.lookupNodes(hash)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(lst.Data) == 0 {
+		return 0, false, nil
+	}
+
+	return lst.Data[0].ContentProperties.Size, true, nil
+}
+
+// Verify the existence of multiple hashes in Amazon Cloud Drive
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	exists := make([]bool, len(hashes))
+	for i, hash := range hashes {
+		var err error
+		exists[i], err = c.VerifyHash(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exists, nil
+}
+
+// Delete a file in Amazon Cloud Drive (by moving it to the trash)
+func (c *Client) Delete(hash string) error {
+
+	lst, err := c.lookupNodes(hash)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range lst.Data {
+		req, err := http.NewRequest("PUT", c.config.MetaDataUrl+"trash/"+node.Id, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Authorization", "Bearer "+c.config.AccessToken)
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return errors.New(fmt.Sprintf("Failed to delete %s: %s", hash, resp.Status))
+		}
+	}
+
+	return nil
+}
+
+// Look up the nodes for a hash by its label
+func (c *Client) lookupNodes(hash string) (*listStruct, error) {
+
+	req, err := http.NewRequest("GET", c.config.MetaDataUrl+"nodes?filters=labels:BLAKE2b-"+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+c.config.AccessToken)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	var lst listStruct
+	if err := dec.Decode(&lst); err != nil {
+		return nil, err
+	}
+
+	return &lst, nil
+}
+
 // List with a prefix string in Amazon Cloud Drive
-func (c *Client) List(_ string, action func(key string)) ([]string, error) {
+// TODO: To be implemented, for now nothing is listed (so a push uploads all objects again)
+func (c *Client) List(_ string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error)
+	close(keys)
+	close(errs)
 
-	return nil, errors.New("To be implemented")
+	return keys, errs
 }
 
 func (c *Client) DownloadWithWriter(_ string, _ io.WriterAt) error {
@@ -191,6 +281,16 @@ func (c *Client) DownloadWithWriter(_ string, _ io.WriterAt) error {
 	return errors.New("To be implemented")
 }
 
+func (c *Client) Ping() error {
+
+	return errors.New("To be implemented")
+}
+
+func (c *Client) DownloadRange(_ string, _, _ int64, _ io.Writer) error {
+
+	return errors.New("To be implemented")
+}
+
 // Get endpoint urls for Amazon Cloud Drive (both for content and meta data access)
 func (c *Client) GetEndpoint() error {
 	req, err := http.NewRequest("GET", "https://drive.amazonaws.com/drive/v1/account/endpoint", nil)
@@ -260,4 +360,10 @@ type endpointStruct struct {
 
 type listStruct struct {
 	Count int `json:"count"`
+	Data  []struct {
+		Id                string `json:"id"`
+		ContentProperties struct {
+			Size int64 `json:"size"`
+		} `json:"contentProperties"`
+	} `json:"data"`
 }
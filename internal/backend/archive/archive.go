@@ -0,0 +1,373 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+const objectsDB = "objects"
+
+// Default initial size of the memory map
+const mapSizeDefault = 1 << 36
+
+// Number of keys that are listed per transaction
+const listBatchSize = 1000
+
+type archiveEnv struct {
+	env      *lmdb.Env
+	dbi      lmdb.DBI
+	readOnly bool
+
+	// Transactions hold a read lock, as growing the map requires that no transactions are active
+	mutex sync.RWMutex
+}
+
+// An environment can only be opened once per process, so share it between clients
+var envs = make(map[string]*archiveEnv)
+var envsMutex sync.Mutex
+
+type Client struct {
+	Path     string
+	ReadOnly bool
+	MapSize  int64
+}
+
+func MakeClient(remote config.RemoteObject) *Client {
+
+	return &Client{
+		Path: remote.ArchivePath,
+		ReadOnly: remote.ArchiveReadOnly,
+		MapSize: remote.ArchiveMapSize}
+}
+
+// Open (or create) the archive file
+func (c *Client) open() (*archiveEnv, error) {
+
+	envsMutex.Lock()
+	defer envsMutex.Unlock()
+
+	if ae, ok := envs[c.Path]; ok {
+		if ae.readOnly && !c.ReadOnly {
+			return nil, errors.New(fmt.Sprintf("Archive is opened read-only: %s", c.Path))
+		}
+		return ae, nil
+	}
+
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+	// TODO: Windows: max size is capped at 32
+	mapSize := c.MapSize
+	if mapSize == 0 {
+		mapSize = mapSizeDefault
+	}
+	env.SetMapSize(mapSize)
+	env.SetMaxDBs(1)
+
+	// Read-only archives may be on read-only media, so do not use a lock file
+	flags := uint(lmdb.NoSubdir)
+	if c.ReadOnly {
+		flags |= lmdb.Readonly | lmdb.NoLock
+	}
+	err = env.Open(c.Path, flags, 0644)
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+
+	ae := &archiveEnv{env: env, readOnly: c.ReadOnly}
+	if c.ReadOnly {
+		err = env.View(func(txn *lmdb.Txn) (err error) {
+			ae.dbi, err = txn.OpenDBI(objectsDB, 0)
+			return err
+		})
+	} else {
+		err = env.Update(func(txn *lmdb.Txn) (err error) {
+			ae.dbi, err = txn.OpenDBI(objectsDB, lmdb.Create)
+			return err
+		})
+	}
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+
+	envs[c.Path] = ae
+	return ae, nil
+}
+
+// Get an object, the contents are only valid during the call to the action
+func (c *Client) get(hash string, action func(val []byte) error) error {
+
+	ae, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	return ae.view(func(txn *lmdb.Txn) error {
+		val, err := txn.Get(ae.dbi, []byte(hash))
+		if err != nil {
+			return err
+		}
+		return action(val)
+	})
+}
+
+// Upload a file into the archive (writes are serialized by the database)
+func (c *Client) UploadWithReader(hash string, r io.Reader) error {
+
+	if c.ReadOnly {
+		return errors.New(fmt.Sprintf("Archive is read-only: %s", c.Path))
+	}
+
+	ae, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	val, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return ae.update(func(txn *lmdb.Txn) error {
+		return txn.Put(ae.dbi, []byte(hash), val, 0)
+	})
+}
+
+func (ae *archiveEnv) view(fn lmdb.TxnOp) error {
+
+	ae.mutex.RLock()
+	defer ae.mutex.RUnlock()
+
+	return ae.env.View(fn)
+}
+
+// Run an update, the map is grown (doubled) when it is full
+func (ae *archiveEnv) update(fn lmdb.TxnOp) error {
+
+	for {
+		ae.mutex.RLock()
+		err := ae.env.Update(fn)
+		ae.mutex.RUnlock()
+		if !lmdb.IsMapFull(err) {
+			return err
+		}
+
+		err = ae.grow()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ae *archiveEnv) grow() error {
+
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	info, err := ae.env.Info()
+	if err != nil {
+		return err
+	}
+	return ae.env.SetMapSize(info.MapSize * 2)
+}
+
+// Verify the existence of a hash
+func (c *Client) VerifyHash(hash string) (bool, error) {
+
+	err := c.get(hash, func(val []byte) error { return nil })
+	if lmdb.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Download a file from the archive
+func (c *Client) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	return c.get(hash, func(val []byte) error {
+		_, err := w.WriteAt(val, 0)
+		return err
+	})
+}
+
+// Download a range of a file (may return less when the range extends beyond the end)
+func (c *Client) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	return c.get(hash, func(val []byte) error {
+		_, err := io.Copy(w, io.NewSectionReader(bytes.NewReader(val), offset, length))
+		return err
+	})
+}
+
+// Check that the archive can be opened
+func (c *Client) Ping() error {
+
+	_, err := c.open()
+	return err
+}
+
+// Get the size of an object
+func (c *Client) Stat(hash string) (int64, bool, error) {
+
+	var size int64
+	err := c.get(hash, func(val []byte) error {
+		size = int64(len(val))
+		return nil
+	})
+	if lmdb.IsNotFound(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return size, true, nil
+}
+
+// Verify the existence of multiple hashes (in a single transaction)
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	ae, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make([]bool, len(hashes))
+	err = ae.view(func(txn *lmdb.Txn) error {
+		for i, hash := range hashes {
+			_, err := txn.Get(ae.dbi, []byte(hash))
+			if err != nil && !lmdb.IsNotFound(err) {
+				return err
+			}
+			exists[i] = err == nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return exists, nil
+}
+
+// Delete an object from the archive
+func (c *Client) Delete(hash string) error {
+
+	if c.ReadOnly {
+		return errors.New(fmt.Sprintf("Archive is read-only: %s", c.Path))
+	}
+
+	ae, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	return ae.update(func(txn *lmdb.Txn) error {
+		err := txn.Del(ae.dbi, []byte(hash), nil)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// List with a prefix string
+func (c *Client) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		err := c.list(prefix, keys)
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return keys, errs
+}
+
+// List in batches, so that no transaction is active while waiting for the receiver of the keys
+func (c *Client) list(prefix string, keys chan<- string) error {
+
+	ae, err := c.open()
+	if err != nil {
+		return err
+	}
+
+	from := []byte(prefix)
+	for {
+		batch := []string{}
+		err = ae.view(func(txn *lmdb.Txn) error {
+			cursor, err := txn.OpenCursor(ae.dbi)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close()
+
+			op, key := uint(lmdb.SetRange), from
+			if len(from) == 0 {
+				op, key = lmdb.First, nil
+			}
+			for len(batch) < listBatchSize {
+				k, _, err := cursor.Get(key, nil, op)
+				if lmdb.IsNotFound(err) {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(string(k), prefix) {
+					return nil
+				}
+				batch = append(batch, string(k))
+				op, key = lmdb.Next, nil
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range batch {
+			keys <- k
+		}
+		if len(batch) < listBatchSize {
+			return nil
+		}
+
+		// Continue after the last key
+		from = append([]byte(batch[len(batch)-1]), 0)
+	}
+}
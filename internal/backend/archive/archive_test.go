@@ -0,0 +1,134 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func makeArchive(t *testing.T) (*Client, string) {
+
+	dir, _ := ioutil.TempDir("", "s3git-archive-backend-")
+	client := MakeClient(config.RemoteObject{Type: config.REMOTE_ARCHIVE, ArchivePath: dir + "/repo.db"})
+	assert.Nil(t, client.Ping())
+
+	return client, dir
+}
+
+func listAll(t *testing.T, client *Client, prefix string) []string {
+
+	keys, errs := client.List(prefix)
+	list := []string{}
+	for key := range keys {
+		list = append(list, key)
+	}
+	assert.Nil(t, <-errs)
+
+	return list
+}
+
+func TestListWithPrefix(t *testing.T) {
+
+	client, dir := makeArchive(t)
+	defer os.RemoveAll(dir)
+
+	// More keys than are listed in a single batch
+	expected := []string{}
+	for i := 0; i < listBatchSize+10; i++ {
+		key := fmt.Sprintf("ab%06d", i)
+		expected = append(expected, key)
+		assert.Nil(t, client.UploadWithReader(key, strings.NewReader(key)))
+	}
+	assert.Nil(t, client.UploadWithReader("aa", strings.NewReader("aa")))
+	assert.Nil(t, client.UploadWithReader("ac", strings.NewReader("ac")))
+
+	assert.Equal(t, expected, listAll(t, client, "ab"))
+	assert.Equal(t, len(expected)+2, len(listAll(t, client, "")))
+	assert.Equal(t, 0, len(listAll(t, client, "ff")))
+}
+
+func TestConcurrentUploads(t *testing.T) {
+
+	client, dir := makeArchive(t)
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("%02x", i)
+			assert.Nil(t, client.UploadWithReader(key, strings.NewReader(strings.Repeat(key, 1000))))
+		}(i)
+	}
+	wg.Wait()
+
+	keys := listAll(t, client, "")
+	assert.Equal(t, 20, len(keys))
+	assert.True(t, sort.StringsAreSorted(keys))
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("%02x", i)
+		var buf bytes.Buffer
+		assert.Nil(t, client.DownloadRange(key, 0, 1<<20, &buf))
+		assert.Equal(t, strings.Repeat(key, 1000), buf.String())
+	}
+}
+
+func TestGrowWhenFull(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-archive-backend-")
+	defer os.RemoveAll(dir)
+	client := MakeClient(config.RemoteObject{Type: config.REMOTE_ARCHIVE, ArchivePath: dir + "/repo.db", ArchiveMapSize: 64 * 1024})
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("%02x", i)
+		assert.Nil(t, client.UploadWithReader(key, bytes.NewReader(make([]byte, 32*1024))))
+	}
+	assert.Equal(t, 10, len(listAll(t, client, "")))
+}
+
+func TestReadOnly(t *testing.T) {
+
+	client, dir := makeArchive(t)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, client.UploadWithReader("ab", strings.NewReader("hello s3git")))
+
+	readOnly := MakeClient(config.RemoteObject{Type: config.REMOTE_ARCHIVE, ArchivePath: client.Path, ArchiveReadOnly: true})
+	assert.NotNil(t, readOnly.UploadWithReader("cd", strings.NewReader("hello s3git")), "Expected error for upload to read-only archive")
+	assert.NotNil(t, readOnly.Delete("ab"), "Expected error for delete from read-only archive")
+
+	var buf bytes.Buffer
+	assert.Nil(t, readOnly.DownloadRange("ab", 6, 100, &buf))
+	assert.Equal(t, "s3git", buf.String())
+
+	// Archive that is opened read-only first (as in a new process) cannot be written to by another client
+	envsMutex.Lock()
+	delete(envs, client.Path)
+	envsMutex.Unlock()
+	assert.Nil(t, readOnly.Ping())
+	assert.NotNil(t, client.UploadWithReader("cd", strings.NewReader("hello s3git")), "Expected error for archive opened read-only")
+}
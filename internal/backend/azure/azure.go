@@ -0,0 +1,450 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package azure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+const apiVersion = "2019-12-12"
+
+// Objects larger than this are uploaded in blocks
+const blockSize = 4 * 1024 * 1024
+
+// Number of blobs listed per request
+var listPageSize = 5000
+
+type Client struct {
+	Container  string
+	Account    string
+	AccountKey string
+	SasToken   string
+	Endpoint   string
+	client     *http.Client
+}
+
+func MakeClient(remote config.RemoteObject) *Client {
+
+	endpoint := remote.AzureEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", remote.AzureAccount)
+	}
+
+	return &Client{
+		Container: remote.AzureContainer,
+		Account: remote.AzureAccount,
+		AccountKey: remote.AzureAccountKey,
+		SasToken: strings.TrimPrefix(remote.AzureSasToken, "?"),
+		Endpoint: strings.TrimRight(endpoint, "/"),
+		client: http.DefaultClient}
+}
+
+// Upload a file to Azure (in blocks when it is large)
+func (c *Client) UploadWithReader(hash string, r io.Reader) error {
+
+	buf := make([]byte, blockSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Fits in a single request
+		header := http.Header{}
+		header.Set("x-ms-blob-type", "BlockBlob")
+		return c.put(hash, nil, header, buf[:n])
+	} else if err != nil {
+		return err
+	}
+
+	blockIds := []string{}
+	for n > 0 {
+		blockId := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blockIds))))
+		err = c.put(hash, url.Values{"comp": {"block"}, "blockid": {blockId}}, http.Header{}, buf[:n])
+		if err != nil {
+			return err
+		}
+		blockIds = append(blockIds, blockId)
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	var blockList bytes.Buffer
+	blockList.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, blockId := range blockIds {
+		blockList.WriteString("<Latest>" + blockId + "</Latest>")
+	}
+	blockList.WriteString("</BlockList>")
+
+	return c.put(hash, url.Values{"comp": {"blocklist"}}, http.Header{}, blockList.Bytes())
+}
+
+func (c *Client) put(hash string, query url.Values, header http.Header, body []byte) error {
+
+	resp, err := c.do("PUT", hash, query, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return newStatusError(resp, "Failed to upload %s: %s", hash)
+	}
+
+	return nil
+}
+
+// Verify the existence of a hash in Azure
+func (c *Client) VerifyHash(hash string) (bool, error) {
+
+	_, exists, err := c.Stat(hash)
+	return exists, err
+}
+
+// Get the size of a blob in Azure
+func (c *Client) Stat(hash string) (int64, bool, error) {
+
+	resp, err := c.do("HEAD", hash, nil, http.Header{}, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, true, nil
+	case http.StatusNotFound:
+		return 0, false, nil
+	}
+
+	return 0, false, newStatusError(resp, "Unexpected status for %s: %s", hash)
+}
+
+// Verify the existence of multiple hashes in Azure
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	exists := make([]bool, len(hashes))
+	for i, hash := range hashes {
+		var err error
+		exists[i], err = c.VerifyHash(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exists, nil
+}
+
+// Delete a blob in Azure
+func (c *Client) Delete(hash string) error {
+
+	resp, err := c.do("DELETE", hash, nil, http.Header{}, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusNotFound:
+		return nil
+	}
+
+	return newStatusError(resp, "Failed to delete %s: %s", hash)
+}
+
+// Download a file from Azure
+func (c *Client) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	body, err := c.get(hash, http.Header{})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	buf := make([]byte, 32*1024)
+	var offset int64
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			_, ew := w.WriteAt(buf[:n], offset)
+			if ew != nil {
+				return ew
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Download a range of a file from Azure (may return less when the range extends beyond the end)
+func (c *Client) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	header := http.Header{}
+	header.Set("x-ms-range", fmt.Sprintf("bytes=%d-", offset))
+	if length < math.MaxInt64-offset {
+		header.Set("x-ms-range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+
+	body, err := c.get(hash, header)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.Copy(w, io.LimitReader(body, length))
+	return err
+}
+
+func (c *Client) get(hash string, header http.Header) (io.ReadCloser, error) {
+
+	resp, err := c.do("GET", hash, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return ioutil.NopCloser(&bytes.Buffer{}), nil // Range starts beyond the end
+	}
+	resp.Body.Close()
+
+	return nil, newStatusError(resp, "Failed to download %s: %s", hash)
+}
+
+// Check that the container can be listed
+func (c *Client) Ping() error {
+
+	_, err := c.listPage("", "", 1)
+	return err
+}
+
+type enumerationResults struct {
+	Blobs      []string `xml:"Blobs>Blob>Name"`
+	NextMarker string   `xml:"NextMarker"`
+}
+
+// List with a prefix string
+func (c *Client) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		marker := ""
+		for {
+			results, err := c.listPage(prefix, marker, listPageSize)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			for _, name := range results.Blobs {
+				keys <- name
+			}
+
+			if results.NextMarker == "" {
+				return
+			}
+			marker = results.NextMarker
+		}
+	}()
+
+	return keys, errs
+}
+
+func (c *Client) listPage(prefix, marker string, maxResults int) (*enumerationResults, error) {
+
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "maxresults": {fmt.Sprint(maxResults)}}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+
+	resp, err := c.do("GET", "", query, http.Header{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp, "Failed to list container %s: %s", c.Container)
+	}
+
+	results := &enumerationResults{}
+	err = xml.NewDecoder(resp.Body).Decode(results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Perform a request for a blob (or the container when the hash is empty)
+func (c *Client) do(method, hash string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+
+	u, err := url.Parse(c.Endpoint + "/" + c.Container)
+	if err != nil {
+		return nil, err
+	}
+	if hash != "" {
+		u.Path += "/" + hash
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	rawQuery := query.Encode()
+	if c.SasToken != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += c.SasToken
+	}
+	u.RawQuery = rawQuery
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body, req.ContentLength = nil, 0
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", apiVersion)
+
+	if c.SasToken == "" {
+		authorization, err := c.sign(req, u.Path, query)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return c.client.Do(req)
+}
+
+// Compute the Shared Key authorization for a request
+func (c *Client) sign(req *http.Request, path string, query url.Values) (string, error) {
+
+	key, err := base64.StdEncoding.DecodeString(c.AccountKey)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Bad account key for %s: %s", c.Account, err))
+	}
+
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = fmt.Sprint(req.ContentLength)
+	}
+
+	// Canonicalized headers
+	msHeaders := []string{}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k+":"+strings.Join(v, ","))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	// Canonicalized resource
+	resource := "/" + c.Account + path
+	params := []string{}
+	for k, v := range query {
+		values := append([]string{}, v...)
+		sort.Strings(values)
+		params = append(params, strings.ToLower(k)+":"+strings.Join(values, ","))
+	}
+	sort.Strings(params)
+	for _, p := range params {
+		resource += "\n" + p
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date (x-ms-date is used instead)
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+
+	return fmt.Sprintf("SharedKey %s:%s", c.Account, base64.StdEncoding.EncodeToString(mac.Sum(nil))), nil
+}
+
+// Error for an unexpected HTTP status
+type statusError struct {
+	statusCode int
+	msg        string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func newStatusError(resp *http.Response, format string, arg string) error {
+	return &statusError{statusCode: resp.StatusCode, msg: fmt.Sprintf(format, arg, resp.Status)}
+}
+
+// Server errors, throttling and timeouts are worth retrying
+func IsRetryableStatus(err error) bool {
+
+	if se, ok := err.(*statusError); ok {
+		return se.statusCode >= 500 || se.statusCode == http.StatusTooManyRequests || se.statusCode == http.StatusRequestTimeout
+	}
+	return false
+}
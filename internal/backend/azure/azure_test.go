@@ -0,0 +1,217 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package azure
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Minimal in-memory emulation of the Blob service (path style, like Azurite)
+type emulator struct {
+	mutex  sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string][]byte
+	sas    bool
+}
+
+func (e *emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.sas && r.URL.Query().Get("sig") == "" || !e.sas && !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey devstoreaccount1:") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/devstoreaccount1/"), "/", 2)
+	if parts[0] != "s3git" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+
+	if len(parts) == 1 {
+		// List blobs
+		names := []string{}
+		for name := range e.blobs {
+			if strings.HasPrefix(name, query.Get("prefix")) && name > query.Get("marker") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		result := struct {
+			XMLName    xml.Name `xml:"EnumerationResults"`
+			Names      []string `xml:"Blobs>Blob>Name"`
+			NextMarker string   `xml:"NextMarker"`
+		}{}
+		max, _ := strconv.Atoi(query.Get("maxresults"))
+		if max > 0 && len(names) > max {
+			names = names[:max]
+			result.NextMarker = names[max-1]
+		}
+		result.Names = names
+		xml.NewEncoder(w).Encode(result)
+		return
+	}
+
+	name := parts[1]
+	switch r.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		switch query.Get("comp") {
+		case "block":
+			e.blocks[name+query.Get("blockid")] = body
+		case "blocklist":
+			list := struct {
+				Latest []string `xml:"Latest"`
+			}{}
+			xml.Unmarshal(body, &list)
+			var blob bytes.Buffer
+			for _, id := range list.Latest {
+				blob.Write(e.blocks[name+id])
+			}
+			e.blobs[name] = blob.Bytes()
+		default:
+			e.blobs[name] = body
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		if _, ok := e.blobs[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(e.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	case "HEAD", "GET":
+		blob, ok := e.blobs[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if rng := r.Header.Get("x-ms-range"); rng != "" {
+			var start, end int
+			n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			if n < 2 || end >= len(blob) {
+				end = len(blob) - 1
+			}
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(blob[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		w.Write(blob)
+	}
+}
+
+func setupEmulator(sas bool) (*httptest.Server, *Client) {
+
+	server := httptest.NewServer(&emulator{blobs: make(map[string][]byte), blocks: make(map[string][]byte), sas: sas})
+
+	remote := config.RemoteObject{Type: config.REMOTE_AZURE, AzureContainer: "s3git", AzureAccount: "devstoreaccount1", AzureEndpoint: server.URL + "/devstoreaccount1"}
+	if sas {
+		remote.AzureSasToken = "?sv=2019-12-12&sp=rwl&sig=c2lnbmF0dXJl"
+	} else {
+		remote.AzureAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	}
+
+	return server, MakeClient(remote)
+}
+
+func TestUploadAndDownload(t *testing.T) {
+
+	for _, sas := range []bool{false, true} {
+		server, client := setupEmulator(sas)
+		assert.Nil(t, client.Ping())
+
+		hash := strings.Repeat("ab", 64)
+		verified, err := client.VerifyHash(hash)
+		assert.Nil(t, err)
+		assert.False(t, verified)
+
+		assert.Nil(t, client.UploadWithReader(hash, strings.NewReader("hello s3git")))
+
+		verified, err = client.VerifyHash(hash)
+		assert.Nil(t, err)
+		assert.True(t, verified)
+
+		var buf bytes.Buffer
+		assert.Nil(t, client.DownloadRange(hash, 6, 100, &buf))
+		assert.Equal(t, "s3git", buf.String())
+
+		size, exists, err := client.Stat(hash)
+		assert.Nil(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, int64(len("hello s3git")), size)
+
+		assert.Nil(t, client.Delete(hash))
+		assert.Nil(t, client.Delete(hash))
+		verified, err = client.VerifyHash(hash)
+		assert.Nil(t, err)
+		assert.False(t, verified)
+
+		server.Close()
+	}
+}
+
+func TestUploadInBlocks(t *testing.T) {
+
+	server, client := setupEmulator(false)
+	defer server.Close()
+
+	large := bytes.Repeat([]byte("s3git"), blockSize/2)
+	hash := strings.Repeat("cd", 64)
+	assert.Nil(t, client.UploadWithReader(hash, bytes.NewReader(large)))
+
+	var buf bytes.Buffer
+	assert.Nil(t, client.DownloadRange(hash, 0, int64(len(large)), &buf))
+	assert.Equal(t, large, buf.Bytes())
+}
+
+func TestList(t *testing.T) {
+
+	server, client := setupEmulator(false)
+	defer server.Close()
+
+	listPageSize = 10
+	defer func() { listPageSize = 5000 }()
+
+	for i := 0; i < 25; i++ {
+		client.UploadWithReader(fmt.Sprintf("0000000%06d", i), strings.NewReader(""))
+	}
+	client.UploadWithReader("1234", strings.NewReader(""))
+
+	count := 0
+	keys, errs := client.List("0000000")
+	for range keys {
+		count++
+	}
+	assert.Nil(t, <-errs)
+	assert.Equal(t, 25, count, "Expected all pages to be listed")
+}
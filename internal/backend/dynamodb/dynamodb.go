@@ -18,6 +18,8 @@ package dynamodb
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -27,13 +29,13 @@ import (
 	"io"
 	"time"
 	"io/ioutil"
+	"strings"
 )
 
 type Client struct {
-	Table     string
-	Region    string
-	AccessKey string
-	SecretKey string
+	Table       string
+	Region      string
+	Credentials *credentials.Credentials
 }
 
 const KEY_NAME = "K"
@@ -44,7 +46,7 @@ func MakeClient(remote config.RemoteObject) (*Client, error) {
 	client := &Client{
 		Table:     remote.DynamoDbTable,
 		Region:    remote.DynamoDbRegion,
-		AccessKey: remote.DynamoDbAccessKey, SecretKey: remote.DynamoDbSecretKey}
+		Credentials: config.NewCredentials(remote)}
 
 	exists, _, err := client.checkTableExists()
 	if err != nil {
@@ -150,14 +152,148 @@ func (c *Client) DownloadWithWriter(hash string, w io.WriterAt) error {
 	return nil
 }
 
+// Download a range of a file from DynamoDB (items are small, so fetch the whole item and slice)
+func (c *Client) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	buf := aws.NewWriteAtBuffer([]byte{})
+	err := c.DownloadWithWriter(hash, buf)
+	if err != nil {
+		return err
+	}
+
+	b := buf.Bytes()
+	if offset >= int64(len(b)) {
+		return nil
+	}
+	if offset+length > int64(len(b)) {
+		length = int64(len(b)) - offset
+	}
+
+	_, err = w.Write(b[offset:offset+length])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Check access to the table (with the credentials of the client)
+func (c *Client) Ping() error {
+
+	exists, _, err := c.checkTableExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New(fmt.Sprintf("Table does not exist: %s", c.Table))
+	}
+
+	return nil
+}
+
+// Get the size of a chunk in DynamoDB
+func (c *Client) Stat(hash string) (int64, bool, error) {
+
+	svc := dynamodb.New(session.New(c.getAwsConfig()))
+
+	hx, _ := hex.DecodeString(hash)
+
+	item := make(map[string]*dynamodb.AttributeValue)
+	item[KEY_NAME] = &dynamodb.AttributeValue{B: hx}
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		Key:       item,
+		TableName: aws.String(c.Table),
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	val, ok := result.Item[VAL_NAME]
+	if !ok {
+		return 0, false, nil
+	}
+
+	return int64(len(val.B)), true, nil
+}
+
+// Maximum number of keys for a single batch get
+const batchGetSize = 100
+
+// Verify the existence of multiple hashes in DynamoDB (in batches)
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	svc := dynamodb.New(session.New(c.getAwsConfig()))
+
+	found := make(map[string]bool)
+	for start := 0; start < len(hashes); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		keys := make([]map[string]*dynamodb.AttributeValue, 0, end-start)
+		for _, hash := range hashes[start:end] {
+			hx, _ := hex.DecodeString(hash)
+			keys = append(keys, map[string]*dynamodb.AttributeValue{KEY_NAME: {B: hx}})
+		}
+
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			c.Table: {Keys: keys, AttributesToGet: []*string{aws.String(KEY_NAME)}}}
+
+		// Retry keys that were not processed (when throttled)
+		for len(requestItems) > 0 {
+			result, err := svc.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range result.Responses[c.Table] {
+				found[hex.EncodeToString(item[KEY_NAME].B)] = true
+			}
+			requestItems = result.UnprocessedKeys
+		}
+	}
+
+	exists := make([]bool, len(hashes))
+	for i, hash := range hashes {
+		exists[i] = found[hash]
+	}
+
+	return exists, nil
+}
+
+// Delete a chunk in DynamoDB
+func (c *Client) Delete(hash string) error {
+
+	svc := dynamodb.New(session.New(c.getAwsConfig()))
+
+	hx, _ := hex.DecodeString(hash)
+
+	item := make(map[string]*dynamodb.AttributeValue)
+	item[KEY_NAME] = &dynamodb.AttributeValue{B: hx}
+
+	_, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:       item,
+		TableName: aws.String(c.Table),
+	})
+
+	return err
+}
+
 // List with a prefix string in DynamoDB
-func (c *Client) List(prefix string, action func(key string)) ([]string, error) {
+func (c *Client) List(prefix string) (<-chan string, <-chan error) {
 
-	/*go*/ func(prefix string, action func(key string), cfg *aws.Config) {
+	keys := make(chan string)
+	errs := make(chan error, 1)
 
-		svc := dynamodb.New(session.New(cfg))
+	go func() {
+		defer close(errs)
+		defer close(keys)
 
-		hx, _ := hex.DecodeString(prefix)
+		svc := dynamodb.New(session.New(c.getAwsConfig()))
+
+		// Prefixes of an odd length cannot be expressed in bytes, so filter on the shorter prefix
+		hx, _ := hex.DecodeString(prefix[:len(prefix)&^1])
 
 		params := &dynamodb.ScanInput{
 			TableName: aws.String(c.Table),
@@ -176,18 +312,21 @@ func (c *Client) List(prefix string, action func(key string)) ([]string, error)
 			},
 			ReturnConsumedCapacity: aws.String("TOTAL"),
 		}
-		resp, err := svc.Scan(params)
+		err := svc.ScanPages(params, func(page *dynamodb.ScanOutput, more bool) bool {
+			for _, k := range page.Items {
+				key := hex.EncodeToString(k[KEY_NAME].B)
+				if strings.HasPrefix(key, prefix) {
+					keys <- key
+				}
+			}
+			return true
+		})
 		if err != nil {
-			return
+			errs <- err
 		}
-		for _, k := range resp.Items {
-			action(hex.EncodeToString(k[KEY_NAME].B))
-		}
-	}(prefix, action, c.getAwsConfig())
-
-	result := make([]string, 0)
+	}()
 
-	return result, nil
+	return keys, errs
 }
 
 func (c *Client) createTable() error {
@@ -244,7 +383,7 @@ func (c *Client) checkTableExists() (exists, created bool, err error) {
 func (c *Client) getAwsConfig() *aws.Config {
 
 	s3Config := &aws.Config{
-		Credentials: credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, ""),
+		Credentials: c.Credentials,
 		Region:      aws.String(c.Region)}
 
 	return s3Config
@@ -0,0 +1,376 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"io"
+	"math"
+	"errors"
+	"fmt"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+
+	"github.com/s3git/s3git-go/internal/kv"
+)
+
+// Encrypting decorator for a back end. Objects are encrypted with AES-256 in GCM mode in
+// segments, so that ranges of an object can be decrypted (and authenticated) independently.
+// The key and nonce prefix of an object are derived from a secret and the name of the object.
+// As objects are named after their content hash, this makes for convergent encryption of leaves
+// and blobs: identical content encrypts identically, so deduplication still works. Prefix,
+// commit, tree and snapshot objects are encrypted under the repo key instead, the key that is
+// used is recorded in a header byte. The nonce of a segment is the nonce prefix along with its
+// index and a flag for the final segment (to detect truncation). Object names stay unchanged.
+type encryptedBackend struct {
+	Backend
+	key        []byte
+	objectType func(hash string) string // Empty when unknown
+}
+
+const encryptionHeaderSize = 1
+const encryptionSegmentSize = 64 * 1024
+const encryptionTagSize = 16
+const encryptedSegmentSize = encryptionSegmentSize + encryptionTagSize
+
+// Keys that objects are encrypted with
+const (
+	encryptionConvergent byte = iota // Derived from the convergence secret (for leaves and blobs)
+	encryptionRepoKey                // Derived from the repo key (for metadata objects)
+)
+
+func MakeEncrypted(client Backend, key []byte, objectType func(hash string) string) Backend {
+
+	return &encryptedBackend{Backend: client, key: key, objectType: objectType}
+}
+
+// Upload an object encrypted
+func (e *encryptedBackend) UploadWithReader(hash string, r io.Reader) error {
+
+	// Objects of unknown type are likely metadata objects (as for tiered remotes)
+	kind := encryptionRepoKey
+	if objType := e.objectType(hash); objType == kv.BLOB || objType == objectTypeLeaf {
+		kind = encryptionConvergent
+	}
+
+	aead, prefix, err := e.aead(hash, kind)
+	if err != nil {
+		return err
+	}
+
+	return e.Backend.UploadWithReader(hash, &encryptingReader{aead: aead, prefix: prefix, r: r, out: []byte{kind}})
+}
+
+// Download and decrypt an object (segments need to be decrypted in order, so the object is
+// downloaded as a single range)
+func (e *encryptedBackend) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	return e.DownloadRange(hash, 0, math.MaxInt64, &sequentialWriterAt{w: w})
+}
+
+// Download and decrypt a range of an object
+func (e *encryptedBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	if length <= 0 {
+		return nil
+	}
+	if length > math.MaxInt64-offset {
+		length = math.MaxInt64 - offset
+	}
+
+	first := offset / encryptionSegmentSize
+	last := (offset + length - 1) / encryptionSegmentSize
+	d := &decryptingWriter{hash: hash, segment: first, skip: offset - first*encryptionSegmentSize, remaining: length, w: w}
+
+	// Header is downloaded along with the range when starting at the first segment
+	cipherOffset := encryptionHeaderSize + first*encryptedSegmentSize
+	if first == 0 {
+		cipherOffset = 0
+		d.header = func(header []byte) (cipher.AEAD, []byte, error) { return e.aead(hash, header[0]) }
+	} else {
+		var err error
+		d.aead, d.prefix, err = e.downloadAEAD(hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	cipherLength := int64(math.MaxInt64) - cipherOffset
+	if last < (math.MaxInt64-encryptionHeaderSize)/encryptedSegmentSize - 1 {
+		cipherLength = encryptionHeaderSize + (last+1)*encryptedSegmentSize - cipherOffset
+	}
+
+	err := e.Backend.DownloadRange(hash, cipherOffset, cipherLength, d)
+	if err != nil {
+		return err
+	}
+
+	return d.close()
+}
+
+// Get the size of an object without the header and the authentication tags
+func (e *encryptedBackend) Stat(hash string) (int64, bool, error) {
+
+	size, exists, err := e.Backend.Stat(hash)
+	if err != nil || !exists {
+		return size, exists, err
+	}
+
+	size -= encryptionHeaderSize
+	segments := (size + encryptedSegmentSize - 1) / encryptedSegmentSize
+	if size -= segments * encryptionTagSize; size < 0 {
+		size = 0
+	}
+	return size, true, nil
+}
+
+// Derive the key and nonce prefix for an object from its name and either the convergence
+// secret or the repo key, so that encrypting an object always gives the same result
+func (e *encryptedBackend) aead(hash string, kind byte) (cipher.AEAD, []byte, error) {
+
+	var secret []byte
+	switch kind {
+	case encryptionConvergent:
+		mac := hmac.New(sha256.New, e.key)
+		mac.Write([]byte("convergent"))
+		secret = mac.Sum(nil)
+	case encryptionRepoKey:
+		secret = e.key
+	default:
+		return nil, nil, errors.New(fmt.Sprintf("Unknown encryption of object %s: %d", hash, kind))
+	}
+
+	mac := hmac.New(sha512.New, secret)
+	mac.Write([]byte(hash))
+	derived := mac.Sum(nil)
+
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, derived[32:36], nil
+}
+
+// Download the header of an object in order to derive its key
+func (e *encryptedBackend) downloadAEAD(hash string) (cipher.AEAD, []byte, error) {
+
+	header := &limitedBuffer{max: encryptionHeaderSize}
+	err := e.Backend.DownloadRange(hash, 0, encryptionHeaderSize, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(header.data) != encryptionHeaderSize {
+		return nil, nil, errors.New(fmt.Sprintf("Encrypted object %s is too short", hash))
+	}
+
+	return e.aead(hash, header.data[0])
+}
+
+// Nonce for a segment: the nonce prefix of the object, the index of the segment (in seven
+// bytes) and a flag for the final segment
+func segmentNonce(prefix []byte, segment int64, final bool) []byte {
+
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(segment))
+	copy(nonce[:4], prefix)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// Encrypt the contents of a reader in segments (preceded by the header)
+type encryptingReader struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	r       io.Reader
+	segment int64
+	next    []byte // Byte that is read ahead to find out whether a segment is the final one
+	out     []byte // Encrypted data that is not read yet
+	done    bool
+}
+
+func (er *encryptingReader) Read(p []byte) (int, error) {
+
+	for len(er.out) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		err := er.seal()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, er.out)
+	er.out = er.out[n:]
+	return n, nil
+}
+
+// Encrypt the next segment
+func (er *encryptingReader) seal() error {
+
+	buf := make([]byte, encryptionSegmentSize+1)
+	n := copy(buf, er.next)
+	m, err := io.ReadFull(er.r, buf[n:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	n += m
+
+	final := n <= encryptionSegmentSize
+	if !final {
+		er.next = buf[encryptionSegmentSize:n]
+		n = encryptionSegmentSize
+	}
+
+	er.out = er.aead.Seal(nil, segmentNonce(er.prefix, er.segment, final), buf[:n], nil)
+	er.segment++
+	er.done = final
+	return nil
+}
+
+// Decrypt the segments of a range of an object as they are written
+type decryptingWriter struct {
+	hash      string
+	aead      cipher.AEAD
+	prefix    []byte
+	header    func(header []byte) (cipher.AEAD, []byte, error) // Set when the range starts with the header
+	buf       []byte
+	segment   int64
+	final     bool
+	skip      int64 // Bytes to skip in the first segment
+	remaining int64 // Bytes to write
+	w         io.Writer
+}
+
+func (d *decryptingWriter) Write(p []byte) (int, error) {
+
+	d.buf = append(d.buf, p...)
+
+	if d.aead == nil {
+		if len(d.buf) < encryptionHeaderSize {
+			return len(p), nil
+		}
+		var err error
+		d.aead, d.prefix, err = d.header(d.buf[:encryptionHeaderSize])
+		if err != nil {
+			return 0, err
+		}
+		d.buf = d.buf[encryptionHeaderSize:]
+	}
+
+	for len(d.buf) >= encryptedSegmentSize {
+		err := d.open(d.buf[:encryptedSegmentSize])
+		if err != nil {
+			return 0, err
+		}
+		d.buf = d.buf[encryptedSegmentSize:]
+	}
+
+	return len(p), nil
+}
+
+// Decrypt a segment, which is either a regular or the final segment
+func (d *decryptingWriter) open(sealed []byte) error {
+
+	if d.final {
+		return errors.New(fmt.Sprintf("Encrypted object %s has data beyond the final segment", d.hash))
+	}
+
+	plain, err := d.aead.Open(nil, segmentNonce(d.prefix, d.segment, false), sealed, nil)
+	if err != nil {
+		plain, err = d.aead.Open(nil, segmentNonce(d.prefix, d.segment, true), sealed, nil)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to decrypt segment %d of object %s: %v", d.segment, d.hash, err))
+		}
+		d.final = true
+	}
+	d.segment++
+
+	if d.skip >= int64(len(plain)) {
+		d.skip -= int64(len(plain))
+		return nil
+	}
+	plain = plain[d.skip:]
+	d.skip = 0
+	if int64(len(plain)) > d.remaining {
+		plain = plain[:d.remaining]
+	}
+	d.remaining -= int64(len(plain))
+
+	_, err = d.w.Write(plain)
+	return err
+}
+
+// Decrypt the remaining (final) segment and check that the range is complete
+func (d *decryptingWriter) close() error {
+
+	if d.aead == nil {
+		return errors.New(fmt.Sprintf("Encrypted object %s is too short", d.hash))
+	}
+	if len(d.buf) > 0 {
+		err := d.open(d.buf)
+		if err != nil {
+			return err
+		}
+		if !d.final {
+			return errors.New(fmt.Sprintf("Encrypted object %s is truncated", d.hash))
+		}
+	}
+	if d.remaining > 0 && !d.final {
+		return errors.New(fmt.Sprintf("Encrypted object %s is truncated", d.hash))
+	}
+
+	return nil
+}
+
+// Write to a WriterAt in sequence
+type sequentialWriterAt struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (s *sequentialWriterAt) Write(p []byte) (int, error) {
+
+	n, err := s.w.WriteAt(p, s.off)
+	s.off += int64(n)
+	return n, err
+}
+
+// Buffer that holds up to a maximum number of bytes
+type limitedBuffer struct {
+	data []byte
+	max  int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+
+	if room := l.max - len(l.data); len(p) > room {
+		l.data = append(l.data, p[:room]...)
+	} else {
+		l.data = append(l.data, p...)
+	}
+	return len(p), nil
+}
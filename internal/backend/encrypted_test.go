@@ -0,0 +1,148 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/s3git/s3git-go/internal/backend/fake"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/s3git/s3git-go/internal/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeEncryptedFake(t *testing.T) (Backend, string) {
+
+	dir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	key := make([]byte, config.EncryptionKeySize)
+	rand.Read(key)
+
+	types := map[string]string{"blob": kv.BLOB, "leaf": objectTypeLeaf, "commit": kv.COMMIT}
+	return MakeEncrypted(fake.MakeClient(config.RemoteObject{Type: config.REMOTE_FAKE, FakeDirectory: dir}), key, func(hash string) string { return types[hash] }), dir
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+
+	client, dir := makeEncryptedFake(t)
+	defer os.RemoveAll(dir)
+
+	for _, size := range []int{0, 1, encryptionSegmentSize, encryptionSegmentSize + 1, 3*encryptionSegmentSize + 5} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		assert.Nil(t, client.UploadWithReader("object", bytes.NewReader(data)))
+
+		stat, exists, err := client.Stat("object")
+		assert.Nil(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, int64(size), stat, "Size is different")
+
+		buf := &limitedBuffer{max: size}
+		assert.Nil(t, client.DownloadWithWriter("object", &writerAtBuffer{buf: buf}))
+		assert.Equal(t, string(data), string(buf.data), "Contents are different for size %d", size)
+
+		// Ranges across segment boundaries
+		for _, r := range [][2]int{{0, 10}, {encryptionSegmentSize - 5, 10}, {size / 2, size}, {1, encryptionSegmentSize * 2}} {
+			var out bytes.Buffer
+			assert.Nil(t, client.DownloadRange("object", int64(r[0]), int64(r[1]), &out))
+			start, end := r[0], r[0]+r[1]
+			if start > size {
+				start = size
+			}
+			if end > size {
+				end = size
+			}
+			assert.Equal(t, string(data[start:end]), out.String(), "Range %v is different for size %d", r, size)
+		}
+	}
+}
+
+func TestEncryptedIsConvergent(t *testing.T) {
+
+	client, dir := makeEncryptedFake(t)
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("s3git"), 1000)
+
+	// Same content under the same name encrypts to the same bytes
+	for _, name := range []string{"blob", "leaf", "commit"} {
+		assert.Nil(t, client.UploadWithReader(name, bytes.NewReader(data)))
+		first, _ := ioutil.ReadFile(dir + "/" + name)
+		assert.Nil(t, client.UploadWithReader(name, bytes.NewReader(data)))
+		second, _ := ioutil.ReadFile(dir + "/" + name)
+
+		assert.Equal(t, first, second, "Encryption is not deterministic for %s", name)
+		assert.False(t, bytes.Contains(first, data[:100]), "Contents are not encrypted")
+
+		var out bytes.Buffer
+		assert.Nil(t, client.DownloadRange(name, 10, 100, &out))
+		assert.Equal(t, string(data[10:110]), out.String(), "Range is different for %s", name)
+	}
+
+	// Leaves and blobs are encrypted with the convergence secret, metadata objects with the repo key
+	for name, kind := range map[string]byte{"blob": encryptionConvergent, "leaf": encryptionConvergent, "commit": encryptionRepoKey} {
+		sealed, _ := ioutil.ReadFile(dir + "/" + name)
+		assert.Equal(t, kind, sealed[0], "Wrong key for %s", name)
+	}
+
+	// Same content under another name encrypts differently
+	blob, _ := ioutil.ReadFile(dir + "/blob")
+	leaf, _ := ioutil.ReadFile(dir + "/leaf")
+	assert.NotEqual(t, blob[encryptionHeaderSize:], leaf[encryptionHeaderSize:])
+}
+
+func TestEncryptedTampering(t *testing.T) {
+
+	client, dir := makeEncryptedFake(t)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 2*encryptionSegmentSize+100)
+	rand.Read(data)
+	assert.Nil(t, client.UploadWithReader("object", bytes.NewReader(data)))
+	sealed, _ := ioutil.ReadFile(dir + "/object")
+
+	var out bytes.Buffer
+
+	// Modified contents
+	modified := append([]byte{}, sealed...)
+	modified[len(modified)/2] ^= 1
+	ioutil.WriteFile(dir + "/object", modified, 0644)
+	assert.NotNil(t, client.DownloadRange("object", 0, int64(len(data)), &out), "Expected error for modified object")
+
+	// Truncated after a segment
+	ioutil.WriteFile(dir + "/object", sealed[:encryptionHeaderSize+encryptedSegmentSize], 0644)
+	out.Reset()
+	assert.NotNil(t, client.DownloadRange("object", 0, int64(len(data)), &out), "Expected error for truncated object")
+
+	// Object under another name
+	ioutil.WriteFile(dir + "/other", sealed, 0644)
+	out.Reset()
+	assert.NotNil(t, client.DownloadRange("other", 0, int64(len(data)), &out), "Expected error for renamed object")
+}
+
+// Buffer to download to in sequence
+type writerAtBuffer struct {
+	buf *limitedBuffer
+}
+
+func (w *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	return w.buf.Write(p)
+}
@@ -0,0 +1,545 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Size of the header of every shard that holds the size of the object followed by its checksum
+const erasureHeaderSize = 8 + erasureChecksumSize
+
+// Size of the checksum that follows the piece of every stripe in a shard (so that a corrupt
+// piece is detected and the stripe is rebuilt from the other shards instead)
+const erasureChecksumSize = 4
+
+var erasureChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Objects are encoded in stripes of this size (the shards hold the pieces of all stripes in
+// order), so that a range can be read and decoded without reading the whole object
+const erasureStripeSize = 1024 * 1024
+
+// Maximum number of stripes that are read and decoded at once
+const erasureStripesPerRead = 8
+
+// Back end that splits every object into data shards plus parity shards (using Reed-Solomon
+// codes) that are stored on different back ends, so that objects can still be read when up
+// to as many back ends as there are parity shards are unavailable
+type erasureCodedBackend struct {
+	shards     []Backend
+	dataShards int
+	encoder    reedsolomon.Encoder
+}
+
+func MakeErasureCoded(shards []Backend, dataShards int) (Backend, error) {
+
+	encoder, err := reedsolomon.New(dataShards, len(shards)-dataShards)
+	if err != nil {
+		return nil, err
+	}
+
+	return &erasureCodedBackend{shards: shards, dataShards: dataShards, encoder: encoder}, nil
+}
+
+// Run an operation for the shards in parallel and return the error per shard
+func (e *erasureCodedBackend) forShards(from, to int, op func(i int, shard Backend) error) []error {
+
+	errs := make([]error, len(e.shards))
+
+	var wg sync.WaitGroup
+	for i := from; i < to; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = op(i, e.shards[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// Get the number of failures and the first error
+func countErrors(errs []error) (failures int, first error) {
+	for _, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failures++
+		}
+	}
+	return
+}
+
+// Get the size of the piece of a stripe in each shard (an empty object is a stripe of a single byte)
+func (e *erasureCodedBackend) pieceSize(stripeLen int64) int64 {
+
+	if stripeLen == 0 {
+		stripeLen = 1
+	}
+	return (stripeLen + int64(e.dataShards) - 1) / int64(e.dataShards)
+}
+
+// Get the size of the piece of a stripe as stored in each shard (followed by its checksum)
+func (e *erasureCodedBackend) storedPieceSize(stripeLen int64) int64 {
+
+	return e.pieceSize(stripeLen) + erasureChecksumSize
+}
+
+// Append the checksum of a piece
+func appendChecksum(piece []byte) []byte {
+
+	checksum := make([]byte, erasureChecksumSize)
+	binary.BigEndian.PutUint32(checksum, crc32.Checksum(piece, erasureChecksumTable))
+	return append(piece, checksum...)
+}
+
+// Get a piece when it matches its checksum (nil otherwise)
+func verifyChecksum(stored []byte) []byte {
+
+	if len(stored) < erasureChecksumSize {
+		return nil
+	}
+	piece, checksum := stored[:len(stored)-erasureChecksumSize], stored[len(stored)-erasureChecksumSize:]
+	if crc32.Checksum(piece, erasureChecksumTable) != binary.BigEndian.Uint32(checksum) {
+		return nil
+	}
+	return piece
+}
+
+// Get the length of a stripe of an object
+func stripeLen(size, stripe int64) int64 {
+
+	if rest := size - stripe*erasureStripeSize; rest < erasureStripeSize {
+		return rest
+	}
+	return erasureStripeSize
+}
+
+// Upload an object as shards (all shards need to be stored). The stripes are streamed to the
+// shards, only the contents of a non-seekable reader are read into memory to get the size
+func (e *erasureCodedBackend) UploadWithReader(hash string, r io.Reader) error {
+
+	size, ok := readerSize(r)
+	if !ok {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	readers := make([]*io.PipeReader, len(e.shards))
+	writers := make([]*io.PipeWriter, len(e.shards))
+	for i := range e.shards {
+		readers[i], writers[i] = io.Pipe()
+	}
+
+	done := make(chan []error, 1)
+	go func() {
+		done <- e.forShards(0, len(e.shards), func(i int, shard Backend) error {
+			err := shard.UploadWithReader(hash, readers[i])
+			if err != nil {
+				// Stop writing to a shard that failed
+				readers[i].CloseWithError(err)
+				return err
+			}
+			// Drain what the shard did not read (eg. when the object is present already)
+			io.Copy(ioutil.Discard, readers[i])
+			return nil
+		})
+	}()
+
+	err := e.writeStripes(r, size, writers)
+	for _, w := range writers {
+		w.CloseWithError(err)
+	}
+
+	_, errUpload := countErrors(<-done)
+	if err != nil {
+		return err
+	}
+	return errUpload
+}
+
+// Encode the stripes of an object and write the pieces to the shards
+func (e *erasureCodedBackend) writeStripes(r io.Reader, size int64, writers []*io.PipeWriter) error {
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, uint64(size))
+	header = appendChecksum(header)
+	for _, w := range writers {
+		_, err := w.Write(header)
+		if err != nil {
+			return err
+		}
+	}
+
+	for stripe := int64(0); stripe == 0 || stripe*erasureStripeSize < size; stripe++ {
+
+		// Limit the capacity so that the padding of the last shard is zeroed
+		n := stripeLen(size, stripe)
+		data := make([]byte, n, n)
+		_, err := io.ReadFull(r, data)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			data = []byte{0} // An empty object cannot be split
+		}
+
+		shards, err := e.encoder.Split(data)
+		if err != nil {
+			return err
+		}
+		err = e.encoder.Encode(shards)
+		if err != nil {
+			return err
+		}
+
+		for i, w := range writers {
+			_, err = w.Write(appendChecksum(shards[i]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get the size of the remainder of a seekable reader
+func readerSize(r io.Reader) (int64, bool) {
+
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+
+	cur, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	_, err = seeker.Seek(cur, io.SeekStart)
+	if err != nil {
+		return 0, false
+	}
+
+	return end - cur, true
+}
+
+// Download and decode a number of stripes of an object, from the data shards or, when data
+// shards are unavailable or their pieces do not match their checksums, rebuilt with the parity shards
+func (e *erasureCodedBackend) downloadStripes(hash string, size, from, to int64) ([]byte, error) {
+
+	offset := erasureHeaderSize + from*e.storedPieceSize(erasureStripeSize)
+	length := int64(0)
+	for stripe := from; stripe <= to; stripe++ {
+		length += e.storedPieceSize(stripeLen(size, stripe))
+	}
+
+	pieces := make([][]byte, len(e.shards))
+	var mutex sync.Mutex
+
+	fetch := func(from, to int) []error {
+		return e.forShards(from, to, func(i int, shard Backend) error {
+			var buf bytes.Buffer
+			err := shard.DownloadRange(hash, offset, length, &buf)
+			if err != nil {
+				return err
+			}
+			if int64(buf.Len()) != length {
+				return errors.New(fmt.Sprintf("Shard %d of object %s is too short", i, hash))
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			pieces[i] = buf.Bytes()
+			return nil
+		})
+	}
+
+	errs := fetch(0, e.dataShards)
+	stripes, available := e.splitStripes(pieces, size, from, to)
+	if available < e.dataShards {
+		fetch(e.dataShards, len(e.shards))
+		stripes, available = e.splitStripes(pieces, size, from, to)
+		if available < e.dataShards {
+			_, err := countErrors(errs)
+			return nil, errors.New(fmt.Sprintf("Too few shards of object %s available (%d of %d needed): %v", hash, available, e.dataShards, err))
+		}
+	}
+
+	var buf bytes.Buffer
+	for s, shards := range stripes {
+		reconstruct := false
+		for i := range shards[:e.dataShards] {
+			if shards[i] == nil {
+				reconstruct = true
+			}
+		}
+		if reconstruct {
+			err := e.encoder.ReconstructData(shards)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := e.encoder.Join(&buf, shards, int(stripeLen(size, from+int64(s))))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Split the downloaded pieces of the shards into the pieces per stripe, leaving out pieces that do not
+// match their checksums. Returns the lowest number of valid pieces of any stripe as available.
+func (e *erasureCodedBackend) splitStripes(pieces [][]byte, size, from, to int64) ([][][]byte, int) {
+
+	stripes := make([][][]byte, 0, to-from+1)
+	available := len(e.shards)
+	pos := int64(0)
+	for stripe := from; stripe <= to; stripe++ {
+		stored := e.storedPieceSize(stripeLen(size, stripe))
+
+		shards := make([][]byte, len(e.shards))
+		valid := 0
+		for i := range pieces {
+			if pieces[i] != nil {
+				shards[i] = verifyChecksum(pieces[i][pos : pos+stored])
+			}
+			if shards[i] != nil {
+				valid++
+			}
+		}
+		if valid < available {
+			available = valid
+		}
+
+		stripes = append(stripes, shards)
+		pos += stored
+	}
+
+	return stripes, available
+}
+
+// Get the size of an object from the header of any shard
+func (e *erasureCodedBackend) size(hash string) (int64, error) {
+
+	var err error
+	for _, shard := range e.shards {
+		var buf bytes.Buffer
+		err = shard.DownloadRange(hash, 0, erasureHeaderSize, &buf)
+		if err == nil && buf.Len() == erasureHeaderSize {
+			if header := verifyChecksum(buf.Bytes()); header != nil {
+				return int64(binary.BigEndian.Uint64(header)), nil
+			}
+			err = errors.New("Header does not match its checksum")
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Failed to read size of object %s: %v", hash, err))
+}
+
+// Download and decode an object (in batches of stripes)
+func (e *erasureCodedBackend) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	return e.DownloadRange(hash, 0, math.MaxInt64, &sequentialWriterAt{w: w})
+}
+
+// Download a range of an object, only the stripes that overlap with the range are downloaded and decoded
+func (e *erasureCodedBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	size, err := e.size(hash)
+	if err != nil {
+		return err
+	}
+
+	if offset > size {
+		offset = size
+	}
+	if length > size-offset {
+		length = size - offset
+	}
+	if length == 0 {
+		return nil
+	}
+
+	first, last := offset/erasureStripeSize, (offset+length-1)/erasureStripeSize
+	for from := first; from <= last; from += erasureStripesPerRead {
+		to := from + erasureStripesPerRead - 1
+		if to > last {
+			to = last
+		}
+
+		data, err := e.downloadStripes(hash, size, from, to)
+		if err != nil {
+			return err
+		}
+
+		// Only write the part of the stripes within the range
+		start, end := offset-from*erasureStripeSize, offset+length-from*erasureStripeSize
+		if start < 0 {
+			start = 0
+		}
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		_, err = w.Write(data[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Verified when all shards are verified (so that pushing again restores missing shards)
+func (e *erasureCodedBackend) VerifyHash(hash string) (bool, error) {
+
+	verified := make([]bool, len(e.shards))
+	_, err := countErrors(e.forShards(0, len(e.shards), func(i int, shard Backend) (err error) {
+		verified[i], err = shard.VerifyHash(hash)
+		return
+	}))
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range verified {
+		if !v {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Exists when enough shards exist to read the object
+func (e *erasureCodedBackend) Stat(hash string) (int64, bool, error) {
+
+	exists, err := e.ExistsMany([]string{hash})
+	if err != nil || !exists[0] {
+		return 0, false, err
+	}
+
+	size, err := e.size(hash)
+	if err != nil {
+		return 0, false, err
+	}
+	return size, true, nil
+}
+
+// Exist when enough shards exist to read the objects
+func (e *erasureCodedBackend) ExistsMany(hashes []string) ([]bool, error) {
+
+	existsPerShard := make([][]bool, len(e.shards))
+	failures, err := countErrors(e.forShards(0, len(e.shards), func(i int, shard Backend) (err error) {
+		existsPerShard[i], err = shard.ExistsMany(hashes)
+		return
+	}))
+	if len(e.shards)-failures < e.dataShards {
+		return nil, err
+	}
+
+	exists := make([]bool, len(hashes))
+	for h := range hashes {
+		count := 0
+		for i := range e.shards {
+			if existsPerShard[i] != nil && existsPerShard[i][h] {
+				count++
+			}
+		}
+		exists[h] = count >= e.dataShards
+	}
+
+	return exists, nil
+}
+
+func (e *erasureCodedBackend) Delete(hash string) error {
+
+	_, err := countErrors(e.forShards(0, len(e.shards), func(i int, shard Backend) error {
+		return shard.Delete(hash)
+	}))
+	return err
+}
+
+// List the keys of which enough shards exist to read the object (as long as enough back ends can be listed)
+func (e *erasureCodedBackend) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+
+		var mutex sync.Mutex
+		counts := make(map[string]int)
+		failures, err := countErrors(e.forShards(0, len(e.shards), func(i int, shard Backend) error {
+			ks, es := shard.List(prefix)
+			for key := range ks {
+				mutex.Lock()
+				counts[key]++
+				mutex.Unlock()
+			}
+			return <-es
+		}))
+
+		for key, count := range counts {
+			if count >= e.dataShards {
+				keys <- key
+			}
+		}
+		close(keys)
+
+		if len(e.shards)-failures < e.dataShards {
+			errs <- err
+		} else {
+			errs <- nil
+		}
+	}()
+
+	return keys, errs
+}
+
+// Accessible when enough back ends are accessible to read objects
+func (e *erasureCodedBackend) Ping() error {
+
+	failures, err := countErrors(e.forShards(0, len(e.shards), func(i int, shard Backend) error {
+		return shard.Ping()
+	}))
+	if len(e.shards)-failures < e.dataShards {
+		return err
+	}
+	return nil
+}
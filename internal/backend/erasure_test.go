@@ -0,0 +1,261 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/s3git/s3git-go/internal/backend/file"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// Back end that fails every operation
+type unavailableBackend struct {
+	Backend
+}
+
+func (u unavailableBackend) UploadWithReader(hash string, r io.Reader) error { return errUnavailable }
+func (u unavailableBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+	return errUnavailable
+}
+func (u unavailableBackend) VerifyHash(hash string) (bool, error)       { return false, errUnavailable }
+func (u unavailableBackend) ExistsMany(hashes []string) ([]bool, error) { return nil, errUnavailable }
+func (u unavailableBackend) Delete(hash string) error                   { return errUnavailable }
+func (u unavailableBackend) Ping() error                                { return errUnavailable }
+func (u unavailableBackend) List(prefix string) (<-chan string, <-chan error) {
+	keys, errs := make(chan string), make(chan error, 1)
+	close(keys)
+	errs <- errUnavailable
+	return keys, errs
+}
+
+func TestErasureCoded(t *testing.T) {
+
+	dirs, shards := []string{}, []Backend{}
+	for i := 0; i < 5; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-erasure-")
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, dir)
+		shards = append(shards, file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir}))
+	}
+	client, err := MakeErasureCoded(shards, 3)
+	assert.Nil(t, err)
+
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(42)).Read(data)
+	hash, empty := strings.Repeat("ab", 64), strings.Repeat("cd", 64)
+	assert.Nil(t, client.UploadWithReader(hash, bytes.NewReader(data)))
+	assert.Nil(t, client.UploadWithReader(empty, bytes.NewReader(nil)))
+
+	// Every back end holds a third of the object (plus header and checksum)
+	info, err := os.Stat(filepath.Join(dirs[4], "ab", "ab", hash))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3334+erasureChecksumSize+erasureHeaderSize), info.Size())
+
+	download := func(client Backend, hash string, offset, length int64) ([]byte, error) {
+		var buf bytes.Buffer
+		err := client.DownloadRange(hash, offset, length, &buf)
+		return buf.Bytes(), err
+	}
+
+	output, err := download(client, hash, 0, 20000)
+	assert.Nil(t, err)
+	assert.Equal(t, data, output)
+	output, err = download(client, empty, 0, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(output))
+
+	size, exists, err := client.Stat(hash)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(10000), size)
+
+	// Reconstruct with a data and a parity back end unavailable
+	degraded := append([]Backend{}, shards...)
+	degraded[1], degraded[3] = unavailableBackend{}, unavailableBackend{}
+	client, _ = MakeErasureCoded(degraded, 3)
+
+	output, err = download(client, hash, 5000, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, data[5000:5100], output)
+
+	exists2, err := client.ExistsMany([]string{hash, strings.Repeat("ef", 64)})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, false}, exists2)
+	assert.Nil(t, client.Ping())
+
+	keys, errs := client.List("ab")
+	list := []string{}
+	for key := range keys {
+		list = append(list, key)
+	}
+	assert.Nil(t, <-errs)
+	assert.Equal(t, []string{hash}, list)
+
+	// Not verified so that a push restores the missing shards
+	_, err = client.VerifyHash(hash)
+	assert.NotNil(t, err)
+
+	// Too many back ends unavailable
+	degraded[0] = unavailableBackend{}
+	client, _ = MakeErasureCoded(degraded, 3)
+	_, err = download(client, hash, 0, 20000)
+	assert.NotNil(t, err)
+	assert.NotNil(t, client.Ping())
+
+	// Missing shard is restored by uploading again
+	os.Remove(filepath.Join(dirs[2], "ab", "ab", hash))
+	client, _ = MakeErasureCoded(shards, 3)
+	verified, err := client.VerifyHash(hash)
+	assert.Nil(t, err)
+	assert.False(t, verified)
+	assert.Nil(t, client.UploadWithReader(hash, bytes.NewReader(data)))
+	verified, _ = client.VerifyHash(hash)
+	assert.True(t, verified)
+}
+
+// Back end that counts the number of bytes that are downloaded
+type countingBackend struct {
+	Backend
+	downloaded int64
+}
+
+func (c *countingBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+	var buf bytes.Buffer
+	err := c.Backend.DownloadRange(hash, offset, length, &buf)
+	c.downloaded += int64(buf.Len())
+	w.Write(buf.Bytes())
+	return err
+}
+
+func TestErasureCodedStripes(t *testing.T) {
+
+	shards, counting := []Backend{}, []*countingBackend{}
+	for i := 0; i < 5; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-erasure-")
+		defer os.RemoveAll(dir)
+		c := &countingBackend{Backend: file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir})}
+		shards, counting = append(shards, c), append(counting, c)
+	}
+	client, _ := MakeErasureCoded(shards, 3)
+
+	// Spans multiple batches of stripes, uploaded from a non-seekable reader
+	data := make([]byte, (erasureStripesPerRead+2)*erasureStripeSize+12345)
+	rand.New(rand.NewSource(42)).Read(data)
+	hash := strings.Repeat("ab", 64)
+	assert.Nil(t, client.UploadWithReader(hash, ioutil.NopCloser(bytes.NewReader(data))))
+
+	output := &limitedBuffer{max: len(data)}
+	assert.Nil(t, client.DownloadWithWriter(hash, &writerAtBuffer{buf: output}))
+	assert.True(t, bytes.Equal(data, output.data), "Contents are different")
+
+	var buf bytes.Buffer
+
+	// Range across a stripe boundary only downloads the two stripes
+	for _, c := range counting {
+		c.downloaded = 0
+	}
+	offset := int64(3*erasureStripeSize - 100)
+	assert.Nil(t, client.DownloadRange(hash, offset, 200, &buf))
+	assert.Equal(t, data[offset:offset+200], buf.Bytes())
+	downloaded := int64(0)
+	for _, c := range counting {
+		downloaded += c.downloaded
+	}
+	assert.True(t, downloaded <= 2*erasureStripeSize + 3*erasureHeaderSize + 3 + 6*erasureChecksumSize, "Downloaded %d bytes for range", downloaded)
+
+	// Reconstruct the last stripes with a data back end unavailable
+	degraded := append([]Backend{}, shards...)
+	degraded[0] = unavailableBackend{}
+	client, _ = MakeErasureCoded(degraded, 3)
+	buf.Reset()
+	offset = int64(len(data) - erasureStripeSize - 500)
+	assert.Nil(t, client.DownloadRange(hash, offset, 1<<30, &buf))
+	assert.True(t, bytes.Equal(data[offset:], buf.Bytes()), "Reconstructed contents are different")
+}
+
+func TestErasureCodedChecksums(t *testing.T) {
+
+	dirs, shards := []string{}, []Backend{}
+	for i := 0; i < 5; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-erasure-")
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, dir)
+		shards = append(shards, file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir}))
+	}
+	client, _ := MakeErasureCoded(shards, 3)
+
+	data := make([]byte, 2*erasureStripeSize+12345)
+	rand.New(rand.NewSource(42)).Read(data)
+	hash := strings.Repeat("ab", 64)
+	assert.Nil(t, client.UploadWithReader(hash, bytes.NewReader(data)))
+
+	corrupt := func(dir string, offset int64) {
+		name := filepath.Join(dir, "ab", "ab", hash)
+		contents, _ := ioutil.ReadFile(name)
+		contents[offset] ^= 0xff
+		ioutil.WriteFile(name, contents, 0644)
+	}
+
+	// Corrupt header and pieces of the data shards in different stripes
+	corrupt(dirs[0], 0)
+	corrupt(dirs[0], erasureHeaderSize+10)
+	corrupt(dirs[1], erasureHeaderSize+client.(*erasureCodedBackend).storedPieceSize(erasureStripeSize)+10)
+
+	var buf bytes.Buffer
+	assert.Nil(t, client.DownloadRange(hash, 0, int64(len(data)), &buf))
+	assert.True(t, bytes.Equal(data, buf.Bytes()), "Stripes are not rebuilt from parity")
+
+	// Too many corrupt pieces in a single stripe
+	corrupt(dirs[2], erasureHeaderSize+10)
+	corrupt(dirs[3], erasureHeaderSize+10)
+	buf.Reset()
+	assert.NotNil(t, client.DownloadRange(hash, 0, 100, &buf))
+}
+
+// Back end that does not read the contents of an upload (eg. as the object is present already)
+type skippingBackend struct {
+	Backend
+}
+
+func (s skippingBackend) UploadWithReader(hash string, r io.Reader) error { return nil }
+
+func TestErasureCodedUploadNotRead(t *testing.T) {
+
+	shards := []Backend{}
+	for i := 0; i < 5; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-erasure-")
+		defer os.RemoveAll(dir)
+		shards = append(shards, file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir}))
+	}
+	shards[2] = skippingBackend{Backend: shards[2]}
+	client, _ := MakeErasureCoded(shards, 3)
+
+	data := make([]byte, 3*erasureStripeSize)
+	assert.Nil(t, client.UploadWithReader(strings.Repeat("ab", 64), bytes.NewReader(data)))
+}
@@ -19,6 +19,8 @@ package fake
 import (
 	"io"
 	"os"
+	"fmt"
+	"errors"
 	"path/filepath"
 	"github.com/s3git/s3git-go/internal/config"
 )
@@ -58,7 +60,7 @@ func (c *Client) VerifyHash(hash string) (bool, error) {
 		return false, err
 	}
 
-	if len(fileList) == 1 && fileList[0] == hash {
+	if len(fileList) == 1 && filepath.Base(fileList[0]) == hash {
 		return true, nil
 	}
 
@@ -82,22 +84,104 @@ func (c *Client) DownloadWithWriter(hash string, w io.WriterAt) error {
 	return nil
 }
 
-// List with a prefix string
-func (c *Client) List(prefix string, action func(key string)) ([]string, error) {
+// Fake downloading a range of a file (may return less when the range extends beyond the end)
+func (c *Client) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	f, err := os.Open(c.Directory + "/" + hash)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, os.SEEK_SET)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, io.LimitReader(f, length))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
 
-	fileList, err := filepath.Glob(c.Directory + "/" + prefix + "*")
+// Check that the directory for the fake back end exists
+func (c *Client) Ping() error {
+
+	fi, err := os.Stat(c.Directory)
 	if err != nil {
-		return []string{}, err
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New(fmt.Sprintf("Not a directory: %s", c.Directory))
 	}
 
-	result := make([]string, 0, len(fileList))
+	return nil
+}
+
+// Get the size of a file
+func (c *Client) Stat(hash string) (int64, bool, error) {
 
-	for _, path := range fileList {
-		_, file := filepath.Split(path)
-		action(file)
+	fi, err := os.Stat(c.Directory + "/" + hash)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
 	}
 
-	return result, nil
+	return fi.Size(), true, nil
+}
+
+// Verify the existence of multiple hashes
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	exists := make([]bool, len(hashes))
+	for i, hash := range hashes {
+		var err error
+		exists[i], err = c.VerifyHash(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exists, nil
+}
+
+// Fake deleting a file
+func (c *Client) Delete(hash string) error {
+
+	err := os.Remove(c.Directory + "/" + hash)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List with a prefix string
+func (c *Client) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		fileList, err := filepath.Glob(c.Directory + "/" + prefix + "*")
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		for _, path := range fileList {
+			_, file := filepath.Split(path)
+			keys <- file
+		}
+	}()
+
+	return keys, errs
 }
 
 // From io/io.go, adapted for io.WriterAt as opposed to io.Writer
@@ -0,0 +1,302 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+// Prefix for temporary files (never returned when listing)
+const tempPrefix = ".tmp-"
+
+type Client struct {
+	Directory string
+}
+
+func MakeClient(remote config.RemoteObject) *Client {
+
+	return &Client{
+		Directory: remote.FileDirectory}
+}
+
+// Get the directory for a hash, sharded by the first two bytes (eg. 'ab/cd/abcd...')
+func (c *Client) shardDir(hash string) string {
+
+	if len(hash) < 4 {
+		return c.Directory
+	}
+	return filepath.Join(c.Directory, hash[0:2], hash[2:4])
+}
+
+func (c *Client) path(hash string) string {
+
+	return filepath.Join(c.shardDir(hash), hash)
+}
+
+// Upload a file by writing to a temporary file that is renamed once safely on disk
+func (c *Client) UploadWithReader(hash string, r io.Reader) error {
+
+	dir := c.shardDir(hash)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // No-op after successful rename
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), c.path(hash))
+	if err != nil {
+		return err
+	}
+
+	// Make sure the rename itself is durable
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Verify the existence of a hash
+func (c *Client) VerifyHash(hash string) (bool, error) {
+
+	_, err := os.Stat(c.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Download a file
+func (c *Client) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	f, err := os.Open(c.path(hash))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	var offset int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			_, ew := w.WriteAt(buf[:n], offset)
+			if ew != nil {
+				return ew
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Download a range of a file (may return less when the range extends beyond the end)
+func (c *Client) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	f, err := os.Open(c.path(hash))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, io.NewSectionReader(f, offset, length))
+	return err
+}
+
+// Check that the directory for the remote exists
+func (c *Client) Ping() error {
+
+	fi, err := os.Stat(c.Directory)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New(fmt.Sprintf("Not a directory: %s", c.Directory))
+	}
+
+	return nil
+}
+
+// Get the size of a file
+func (c *Client) Stat(hash string) (int64, bool, error) {
+
+	fi, err := os.Stat(c.path(hash))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	return fi.Size(), true, nil
+}
+
+// Verify the existence of multiple hashes
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	exists := make([]bool, len(hashes))
+	for i, hash := range hashes {
+		var err error
+		exists[i], err = c.VerifyHash(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exists, nil
+}
+
+// Delete a file
+func (c *Client) Delete(hash string) error {
+
+	err := os.Remove(c.path(hash))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List with a prefix string, keys are sent as they are found
+func (c *Client) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		err := c.list(prefix, keys)
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return keys, errs
+}
+
+func (c *Client) list(prefix string, keys chan<- string) error {
+
+	// Only descend into shard directories that are compatible with the prefix
+	matches := func(name string, start int) bool {
+		if len(name) != 2 {
+			return false
+		}
+		if len(prefix) <= start {
+			return true
+		}
+		p := prefix[start:]
+		if len(p) > 2 {
+			p = p[:2]
+		}
+		return strings.HasPrefix(name, p)
+	}
+
+	level1, err := readDirNames(c.Directory)
+	if err != nil {
+		return err
+	}
+
+	for _, l1 := range level1 {
+		if !matches(l1, 0) {
+			continue
+		}
+		level2, err := readDirNames(filepath.Join(c.Directory, l1))
+		if err != nil {
+			return err
+		}
+		for _, l2 := range level2 {
+			if !matches(l2, 2) {
+				continue
+			}
+			files, err := readDirNames(filepath.Join(c.Directory, l1, l2))
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if strings.HasPrefix(file, tempPrefix) || !strings.HasPrefix(file, prefix) {
+					continue
+				}
+				keys <- file
+			}
+		}
+	}
+
+	return nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	fi, err := d.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{}, nil
+	}
+
+	return d.Readdirnames(-1)
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package file
+
+import (
+	"bytes"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestUploadAndDownload(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-file-backend-")
+	defer os.RemoveAll(dir)
+
+	client := MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir})
+	assert.Nil(t, client.Ping())
+
+	hash := strings.Repeat("ab", 64)
+	verified, err := client.VerifyHash(hash)
+	assert.Nil(t, err)
+	assert.False(t, verified)
+
+	assert.Nil(t, client.UploadWithReader(hash, strings.NewReader("hello s3git")))
+
+	_, err = os.Stat(filepath.Join(dir, "ab", "ab", hash))
+	assert.Nil(t, err, "Expected object in sharded directory")
+
+	verified, err = client.VerifyHash(hash)
+	assert.Nil(t, err)
+	assert.True(t, verified)
+
+	var buf bytes.Buffer
+	assert.Nil(t, client.DownloadRange(hash, 6, 100, &buf))
+	assert.Equal(t, "s3git", buf.String())
+
+	// No temporary files are left behind
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "ab", "ab"))
+	assert.Equal(t, 1, len(files))
+
+	size, exists, err := client.Stat(hash)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(len("hello s3git")), size)
+
+	exists2, err := client.ExistsMany([]string{strings.Repeat("cd", 64), hash})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false, true}, exists2)
+
+	assert.Nil(t, client.Delete(hash))
+	assert.Nil(t, client.Delete(hash), "Expected deleting a missing object to succeed")
+	_, exists, err = client.Stat(hash)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestList(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-file-backend-")
+	defer os.RemoveAll(dir)
+
+	client := MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir})
+
+	hashes := []string{strings.Repeat("0", 128), "00000001" + strings.Repeat("f", 120), "0001" + strings.Repeat("e", 124), strings.Repeat("a", 128)}
+	for _, hash := range hashes {
+		assert.Nil(t, client.UploadWithReader(hash, strings.NewReader(hash)))
+	}
+
+	list := func(prefix string) []string {
+		keys := []string{}
+		ch, errs := client.List(prefix)
+		for key := range ch {
+			keys = append(keys, key)
+		}
+		assert.Nil(t, <-errs)
+		sort.Strings(keys)
+		return keys
+	}
+
+	assert.Equal(t, hashes[0:2], list("0000000"))
+	assert.Equal(t, hashes[0:3], list("000"))
+	assert.Equal(t, hashes[0:3], list("0"))
+	assert.Equal(t, 4, len(list("")))
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"io"
+	"time"
+
+	"github.com/s3git/s3git-go/internal/metrics"
+)
+
+// Decorator for a back end that measures the number, failures and duration of operations
+type instrumentedBackend struct {
+	Backend
+}
+
+func MakeInstrumented(client Backend) Backend {
+
+	return &instrumentedBackend{Backend: client}
+}
+
+// Measure an operation (and trace it as a span)
+func measure(op string, f func() error) error {
+
+	start := time.Now()
+	end := metrics.StartSpan("backend." + op)
+
+	err := f()
+
+	metrics.BackendRequests.Add(op, 1)
+	metrics.BackendDuration.Since(op, start)
+	if err != nil {
+		metrics.BackendErrors.Add(op, 1)
+	}
+	end(err)
+
+	return err
+}
+
+func (i *instrumentedBackend) UploadWithReader(hash string, r io.Reader) error {
+	return measure("upload", func() error {
+		return i.Backend.UploadWithReader(hash, r)
+	})
+}
+
+func (i *instrumentedBackend) DownloadWithWriter(hash string, w io.WriterAt) error {
+	return measure("download", func() error {
+		return i.Backend.DownloadWithWriter(hash, w)
+	})
+}
+
+func (i *instrumentedBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+	return measure("download_range", func() error {
+		return i.Backend.DownloadRange(hash, offset, length, w)
+	})
+}
+
+func (i *instrumentedBackend) VerifyHash(hash string) (verified bool, err error) {
+	err = measure("verify", func() (err error) {
+		verified, err = i.Backend.VerifyHash(hash)
+		return
+	})
+	return
+}
+
+func (i *instrumentedBackend) Stat(hash string) (size int64, exists bool, err error) {
+	err = measure("stat", func() (err error) {
+		size, exists, err = i.Backend.Stat(hash)
+		return
+	})
+	return
+}
+
+func (i *instrumentedBackend) ExistsMany(hashes []string) (exists []bool, err error) {
+	err = measure("exists_many", func() (err error) {
+		exists, err = i.Backend.ExistsMany(hashes)
+		return
+	})
+	return
+}
+
+func (i *instrumentedBackend) Delete(hash string) error {
+	return measure("delete", func() error {
+		return i.Backend.Delete(hash)
+	})
+}
+
+func (i *instrumentedBackend) Ping() error {
+	return measure("ping", func() error {
+		return i.Backend.Ping()
+	})
+}
+
+// Measure a listing up to the moment the error channel yields
+func (i *instrumentedBackend) List(prefix string) (<-chan string, <-chan error) {
+
+	keys, errs := i.Backend.List(prefix)
+
+	measuredErrs := make(chan error, 1)
+	go func() {
+		defer close(measuredErrs)
+		start, end := time.Now(), metrics.StartSpan("backend.list")
+		err := <-errs
+		metrics.BackendRequests.Add("list", 1)
+		metrics.BackendDuration.Since("list", start)
+		if err != nil {
+			metrics.BackendErrors.Add("list", 1)
+		}
+		end(err)
+		measuredErrs <- err
+	}()
+
+	return keys, measuredErrs
+}
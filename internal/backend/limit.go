@@ -0,0 +1,218 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+// Token bucket where callers that take more than available run into debt and wait it off,
+// so that concurrent transfers share the rate
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate, burst float64) *limiter {
+	return &limiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Take tokens and wait until they are paid for
+func (l *limiter) wait(n int) {
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+
+	if debt < 0 {
+		sleep(time.Duration(-debt / l.rate * float64(time.Second)))
+	}
+}
+
+// Limiters are shared per remote, as clients are created for every pull on demand
+var (
+	limitersMutex sync.Mutex
+	limiters      = make(map[string]*limiter)
+)
+
+func getLimiter(name string, rate, burst float64) *limiter {
+
+	key := fmt.Sprintf("%s/%g/%g", name, rate, burst)
+
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+
+	l, ok := limiters[key]
+	if !ok {
+		l = newLimiter(rate, burst)
+		limiters[key] = l
+	}
+	return l
+}
+
+// Decorator for a back end that limits bandwidth and request rate
+type limitedBackend struct {
+	Backend
+	bytes    *limiter // nil when bandwidth is unlimited
+	requests *limiter // nil when request rate is unlimited
+}
+
+// Limit the bandwidth and request rate for a remote (or return the client when unlimited)
+func MakeLimited(name string, client Backend, limits *config.LimitsObject) Backend {
+
+	if limits == nil || (limits.BytesPerSecond <= 0 && limits.RequestsPerSecond <= 0) {
+		return client
+	}
+
+	l := &limitedBackend{Backend: client}
+	if limits.BytesPerSecond > 0 {
+		l.bytes = getLimiter(name+"/bytes", float64(limits.BytesPerSecond), float64(limits.BytesPerSecond))
+	}
+	if limits.RequestsPerSecond > 0 {
+		l.requests = getLimiter(name+"/requests", limits.RequestsPerSecond, math.Max(1, limits.RequestsPerSecond))
+	}
+	return l
+}
+
+func (l *limitedBackend) request() {
+	if l.requests != nil {
+		l.requests.wait(1)
+	}
+}
+
+func (l *limitedBackend) transfer(n int) {
+	if l.bytes != nil && n > 0 {
+		l.bytes.wait(n)
+	}
+}
+
+func (l *limitedBackend) UploadWithReader(hash string, r io.Reader) error {
+
+	l.request()
+	if l.bytes == nil {
+		return l.Backend.UploadWithReader(hash, r)
+	}
+	lr := &limitedReader{r: r, l: l}
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		// Keep the reader seekable (so uploads can be retried)
+		return l.Backend.UploadWithReader(hash, &limitedReadSeeker{limitedReader: lr, s: seeker})
+	}
+	return l.Backend.UploadWithReader(hash, lr)
+}
+
+func (l *limitedBackend) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	l.request()
+	if l.bytes == nil {
+		return l.Backend.DownloadWithWriter(hash, w)
+	}
+	return l.Backend.DownloadWithWriter(hash, &limitedWriterAt{w: w, l: l})
+}
+
+func (l *limitedBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	l.request()
+	if l.bytes == nil {
+		return l.Backend.DownloadRange(hash, offset, length, w)
+	}
+	return l.Backend.DownloadRange(hash, offset, length, &limitedWriter{w: w, l: l})
+}
+
+func (l *limitedBackend) VerifyHash(hash string) (bool, error) {
+	l.request()
+	return l.Backend.VerifyHash(hash)
+}
+
+func (l *limitedBackend) Stat(hash string) (int64, bool, error) {
+	l.request()
+	return l.Backend.Stat(hash)
+}
+
+func (l *limitedBackend) ExistsMany(hashes []string) ([]bool, error) {
+	l.request()
+	return l.Backend.ExistsMany(hashes)
+}
+
+func (l *limitedBackend) Delete(hash string) error {
+	l.request()
+	return l.Backend.Delete(hash)
+}
+
+func (l *limitedBackend) List(prefix string) (<-chan string, <-chan error) {
+	l.request()
+	return l.Backend.List(prefix)
+}
+
+func (l *limitedBackend) Ping() error {
+	l.request()
+	return l.Backend.Ping()
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *limitedBackend
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.l.transfer(n)
+	return n, err
+}
+
+type limitedReadSeeker struct {
+	*limitedReader
+	s io.Seeker
+}
+
+func (lrs *limitedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return lrs.s.Seek(offset, whence)
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *limitedBackend
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	n, err := lw.w.Write(p)
+	lw.l.transfer(n)
+	return n, err
+}
+
+type limitedWriterAt struct {
+	w io.WriterAt
+	l *limitedBackend
+}
+
+func (lw *limitedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := lw.w.WriteAt(p, off)
+	lw.l.transfer(n)
+	return n, err
+}
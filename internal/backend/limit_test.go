@@ -0,0 +1,72 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitBandwidth(t *testing.T) {
+
+	var slept time.Duration
+	sleep = func(d time.Duration) { slept += d }
+	defer func() { sleep = func(time.Duration) {} }()
+
+	flaky := &flakyBackend{data: make([]byte, 3000)}
+	client := MakeLimited("bandwidth", flaky, &config.LimitsObject{BytesPerSecond: 1000})
+
+	// First second is allowed as a burst
+	var buf bytes.Buffer
+	assert.Nil(t, client.DownloadRange("aa", 0, 1000, &buf))
+	assert.True(t, slept < 10*time.Millisecond)
+
+	assert.Nil(t, client.DownloadRange("aa", 0, 2000, &buf))
+	assert.InDelta(t, float64(2*time.Second), float64(slept), float64(50*time.Millisecond))
+
+	// Upload stays seekable so that it can be retried
+	slept = 0
+	assert.Nil(t, MakeRetrying(client, MakeRetryPolicy(nil), isFlaky).UploadWithReader("aa", bytes.NewReader(make([]byte, 500))))
+	assert.Equal(t, 500, len(flaky.uploaded))
+	assert.InDelta(t, float64(2500*time.Millisecond), float64(slept), float64(50*time.Millisecond))
+}
+
+func TestLimitRequests(t *testing.T) {
+
+	// Time does not pass while sleeping, so every request waits off the debt of all requests before it
+	var lastSleep time.Duration
+	sleep = func(d time.Duration) { lastSleep = d }
+	defer func() { sleep = func(time.Duration) {} }()
+
+	client := MakeLimited("requests", &flakyBackend{}, &config.LimitsObject{RequestsPerSecond: 10})
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, client.Ping())
+	}
+	assert.Equal(t, time.Duration(0), lastSleep)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, client.Ping())
+	}
+	assert.InDelta(t, float64(500*time.Millisecond), float64(lastSleep), float64(50*time.Millisecond))
+
+	// Without limits the client is not wrapped
+	unlimited := &flakyBackend{}
+	assert.Equal(t, unlimited, MakeLimited("none", unlimited, &config.LimitsObject{Concurrency: 4}))
+}
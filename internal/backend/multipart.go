@@ -0,0 +1,143 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"io"
+
+	"github.com/s3git/s3git-go/internal/kv"
+)
+
+// Back end that can upload large objects in parts (in parallel and resumable)
+type MultipartUploader interface {
+	// Upload an object in parts that are a multiple of the alignment in size (eg. the leaf
+	// size), an interrupted upload is resumed when uploading the same object again. The
+	// request function (if not nil) is called before every request to the back end
+	UploadMultipart(hash string, r io.ReaderAt, size, align int64, concurrency int, request func()) error
+	// Abort the upload in progress for an object (that is not going to be resumed)
+	AbortMultipart(hash string) error
+}
+
+// Get the multipart uploader for a client (false when the back end does not support it, or
+// when objects are encrypted as parts of the ciphertext do not map to leaves)
+func GetMultipartUploader(client Backend) (MultipartUploader, bool) {
+
+	switch c := client.(type) {
+	case *instrumentedBackend:
+		if inner, ok := GetMultipartUploader(c.Backend); ok {
+			return &instrumentedMultipart{inner}, true
+		}
+	case *retryingBackend:
+		if inner, ok := GetMultipartUploader(c.Backend); ok {
+			return &retryingMultipart{MultipartUploader: inner, r: c}, true
+		}
+	case *limitedBackend:
+		if inner, ok := GetMultipartUploader(c.Backend); ok {
+			return &limitedMultipart{MultipartUploader: inner, l: c}, true
+		}
+	case *encryptedBackend:
+		return nil, false
+	case MultipartUploader:
+		return c, true
+	}
+	return nil, false
+}
+
+type instrumentedMultipart struct {
+	MultipartUploader
+}
+
+func (i *instrumentedMultipart) UploadMultipart(hash string, r io.ReaderAt, size, align int64, concurrency int, request func()) error {
+	return measure("uploadmultipart", func() error {
+		return i.MultipartUploader.UploadMultipart(hash, r, size, align, concurrency, request)
+	})
+}
+
+func (i *instrumentedMultipart) AbortMultipart(hash string) error {
+	return measure("abortmultipart", func() error {
+		return i.MultipartUploader.AbortMultipart(hash)
+	})
+}
+
+// Retries resume the upload (uploading the missing parts only)
+type retryingMultipart struct {
+	MultipartUploader
+	r *retryingBackend
+}
+
+func (rm *retryingMultipart) UploadMultipart(hash string, r io.ReaderAt, size, align int64, concurrency int, request func()) error {
+	return rm.r.do("uploadmultipart", func() error {
+		return rm.MultipartUploader.UploadMultipart(hash, r, size, align, concurrency, request)
+	})
+}
+
+func (rm *retryingMultipart) AbortMultipart(hash string) error {
+	return rm.r.do("abortmultipart", func() error {
+		return rm.MultipartUploader.AbortMultipart(hash)
+	})
+}
+
+type limitedMultipart struct {
+	MultipartUploader
+	l *limitedBackend
+}
+
+// Every request of an upload is charged (eg. for each part and to complete the upload)
+func (lm *limitedMultipart) UploadMultipart(hash string, r io.ReaderAt, size, align int64, concurrency int, request func()) error {
+
+	limited := func() {
+		lm.l.request()
+		if request != nil {
+			request()
+		}
+	}
+	if lm.l.bytes != nil {
+		r = &limitedReaderAt{r: r, l: lm.l}
+	}
+	return lm.MultipartUploader.UploadMultipart(hash, r, size, align, concurrency, limited)
+}
+
+func (lm *limitedMultipart) AbortMultipart(hash string) error {
+	lm.l.request()
+	return lm.MultipartUploader.AbortMultipart(hash)
+}
+
+type limitedReaderAt struct {
+	r io.ReaderAt
+	l *limitedBackend
+}
+
+func (lr *limitedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := lr.r.ReadAt(p, off)
+	lr.l.transfer(n)
+	return n, err
+}
+
+// Upload IDs of multipart uploads are stored in the KV (so that a push can resume them)
+type kvUploadStore struct{}
+
+func (kvUploadStore) GetMultipartUpload(key string) (string, bool, error) {
+	return kv.GetMultipartUpload(key)
+}
+
+func (kvUploadStore) AddMultipartUpload(key, uploadId string) error {
+	return kv.AddMultipartUpload(key, uploadId)
+}
+
+func (kvUploadStore) RemoveMultipartUpload(key string) error {
+	return kv.RemoveMultipartUpload(key)
+}
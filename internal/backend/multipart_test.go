@@ -0,0 +1,95 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// Back end that uploads in parts, failing the first number of uploads
+type multipartBackend struct {
+	flakyBackend
+	aborted bool
+	parts   int // Number of parts to make requests for
+}
+
+func (m *multipartBackend) UploadMultipart(hash string, r io.ReaderAt, size, align int64, concurrency int, request func()) error {
+	// Request to create the upload, for every part and to complete the upload
+	for i := 0; i < m.parts+2 && request != nil; i++ {
+		request()
+	}
+	data := make([]byte, size)
+	r.ReadAt(data, 0)
+	if m.fail() {
+		return errFlaky
+	}
+	m.uploaded = data
+	return nil
+}
+
+func (m *multipartBackend) AbortMultipart(hash string) error {
+	m.aborted = true
+	return nil
+}
+
+func TestMultipartUploaderThroughDecorators(t *testing.T) {
+
+	multipart := &multipartBackend{flakyBackend: flakyBackend{failures: 2}}
+	client := MakeInstrumented(MakeRetrying(MakeLimited("multipart", multipart, &config.LimitsObject{BytesPerSecond: 1 << 30}), MakeRetryPolicy(nil), isFlaky))
+
+	uploader, ok := GetMultipartUploader(client)
+	assert.True(t, ok)
+
+	// Failed uploads are retried
+	err := uploader.UploadMultipart("aa", bytes.NewReader([]byte("content")), 7, 1, 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, multipart.calls)
+	assert.Equal(t, []byte("content"), multipart.uploaded)
+
+	assert.Nil(t, uploader.AbortMultipart("aa"))
+	assert.True(t, multipart.aborted)
+
+	// Parts of encrypted objects do not map to leaves
+	_, ok = GetMultipartUploader(MakeInstrumented(MakeEncrypted(multipart, make([]byte, config.EncryptionKeySize), objectTypeFromKV)))
+	assert.False(t, ok)
+
+	_, ok = GetMultipartUploader(MakeInstrumented(&flakyBackend{}))
+	assert.False(t, ok)
+}
+
+func TestLimitMultipartRequests(t *testing.T) {
+
+	var lastSleep time.Duration
+	sleep = func(d time.Duration) { lastSleep = d }
+	defer func() { sleep = func(time.Duration) {} }()
+
+	multipart := &multipartBackend{parts: 8}
+	uploader, ok := GetMultipartUploader(MakeLimited("multipart-requests", multipart, &config.LimitsObject{RequestsPerSecond: 1}))
+	assert.True(t, ok)
+
+	// Every request beyond the first one waits a second
+	requests := 0
+	assert.Nil(t, uploader.UploadMultipart("aa", bytes.NewReader([]byte("content")), 7, 1, 1, func() { requests++ }))
+	assert.Equal(t, 10, requests)
+	assert.InDelta(t, float64(9*time.Second), float64(lastSleep), float64(50*time.Millisecond))
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Back end that only allows reads (for alternates that are fallen back to)
+type readOnlyBackend struct {
+	Backend
+	name string
+}
+
+func MakeReadOnly(name string, client Backend) Backend {
+
+	return &readOnlyBackend{Backend: client, name: name}
+}
+
+func (r *readOnlyBackend) UploadWithReader(hash string, rd io.Reader) error {
+
+	return errors.New(fmt.Sprintf("Cannot upload to read-only remote: %s", r.name))
+}
+
+func (r *readOnlyBackend) Delete(hash string) error {
+
+	return errors.New(fmt.Sprintf("Cannot delete from read-only remote: %s", r.name))
+}
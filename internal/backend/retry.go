@@ -0,0 +1,274 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/s3git/s3git-go/internal/metrics"
+)
+
+const (
+	RetryMaxAttemptsDefault = 5
+	RetryBackoffDefault     = 100 * time.Millisecond
+	RetryMaxBackoffDefault  = 10 * time.Second
+	RetryJitterDefault      = 0.5
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+}
+
+// Get the retry policy for a remote
+func MakeRetryPolicy(retry *config.RetryObject) RetryPolicy {
+
+	policy := RetryPolicy{MaxAttempts: RetryMaxAttemptsDefault, Backoff: RetryBackoffDefault, MaxBackoff: RetryMaxBackoffDefault, Jitter: RetryJitterDefault}
+	if retry == nil {
+		return policy
+	}
+
+	if retry.MaxAttempts > 0 {
+		policy.MaxAttempts = retry.MaxAttempts
+	}
+	if retry.BackoffMillis > 0 {
+		policy.Backoff = time.Duration(retry.BackoffMillis) * time.Millisecond
+	}
+	if retry.MaxBackoffMillis > 0 {
+		policy.MaxBackoff = time.Duration(retry.MaxBackoffMillis) * time.Millisecond
+	}
+	if retry.Jitter > 0 && retry.Jitter <= 1 {
+		policy.Jitter = retry.Jitter
+	}
+
+	return policy
+}
+
+// Get the backoff before the given retry (exponential with part of it randomized)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+
+	d := p.Backoff
+	for i := 0; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	jitter := time.Duration(float64(d) * p.Jitter)
+	if jitter > 0 {
+		d = d - jitter + time.Duration(rand.Int63n(int64(jitter)+1))
+	}
+
+	return d
+}
+
+// Can be replaced for testing
+var sleep = time.Sleep
+
+// Retrying decorator for a back end. As objects are content addressed, every operation can
+// safely be repeated. Uploads are only retried when the reader can be rewound.
+type retryingBackend struct {
+	Backend
+	policy    RetryPolicy
+	retryable func(err error) bool
+}
+
+func MakeRetrying(client Backend, policy RetryPolicy, retryable func(err error) bool) Backend {
+
+	return &retryingBackend{Backend: client, policy: policy, retryable: retryable}
+}
+
+// Run an operation until it succeeds, fails with an error that is not retryable, or attempts are exhausted
+func (r *retryingBackend) do(name string, op func() error) error {
+
+	var err error
+	for attempt := 0; attempt < r.policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			metrics.BackendRetries.Add(name, 1)
+			sleep(r.policy.backoff(attempt - 1))
+		}
+
+		err = op()
+		if err == nil || !r.retryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (r *retryingBackend) UploadWithReader(hash string, rd io.Reader) error {
+
+	seeker, ok := rd.(io.Seeker)
+	if !ok {
+		return r.Backend.UploadWithReader(hash, rd)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return r.Backend.UploadWithReader(hash, rd)
+	}
+
+	first := true
+	return r.do("upload", func() error {
+		if !first {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		first = false
+		return r.Backend.UploadWithReader(hash, rd)
+	})
+}
+
+func (r *retryingBackend) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	return r.do("download", func() error {
+		return r.Backend.DownloadWithWriter(hash, w)
+	})
+}
+
+// Download a range, a retry resumes after the part that was already written
+func (r *retryingBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	cw := &countingWriter{w: w}
+	return r.do("download_range", func() error {
+		if cw.n >= length {
+			return nil
+		}
+		return r.Backend.DownloadRange(hash, offset+cw.n, length-cw.n, cw)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (r *retryingBackend) VerifyHash(hash string) (verified bool, err error) {
+
+	err = r.do("verify", func() error {
+		verified, err = r.Backend.VerifyHash(hash)
+		return err
+	})
+	return
+}
+
+func (r *retryingBackend) Stat(hash string) (size int64, exists bool, err error) {
+
+	err = r.do("stat", func() error {
+		size, exists, err = r.Backend.Stat(hash)
+		return err
+	})
+	return
+}
+
+func (r *retryingBackend) ExistsMany(hashes []string) (exists []bool, err error) {
+
+	err = r.do("exists_many", func() error {
+		exists, err = r.Backend.ExistsMany(hashes)
+		return err
+	})
+	return
+}
+
+func (r *retryingBackend) Delete(hash string) error {
+
+	return r.do("delete", func() error {
+		return r.Backend.Delete(hash)
+	})
+}
+
+func (r *retryingBackend) Ping() error {
+
+	return r.do("ping", func() error {
+		return r.Backend.Ping()
+	})
+}
+
+// List with a prefix, a retry lists again but skips the keys that were already sent
+func (r *retryingBackend) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		sent := make(map[string]bool)
+		err := r.do("list", func() error {
+			ks, es := r.Backend.List(prefix)
+			for key := range ks {
+				if !sent[key] {
+					sent[key] = true
+					keys <- key
+				}
+			}
+			return <-es
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return keys, errs
+}
+
+// Network errors and unexpected ends of streams are worth retrying
+func IsRetryableNetworkError(err error) bool {
+
+	_, isNetErr := err.(net.Error)
+	return isNetErr || err == io.ErrUnexpectedEOF
+}
+
+// Retryable errors for S3 and DynamoDB: server errors, throttling and timeouts
+func IsRetryableAwsError(err error) bool {
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		if reqErr.StatusCode() >= 500 || reqErr.StatusCode() == 429 {
+			return true
+		}
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "Throttling", "ThrottlingException", "ThrottledException", "RequestThrottled", "SlowDown",
+			"ProvisionedThroughputExceededException", "RequestLimitExceeded", "RequestTimeout",
+			"RequestTimeoutException", "InternalError", "ServiceUnavailable", "RequestError":
+			return true
+		}
+		if awsErr.OrigErr() != nil {
+			return IsRetryableNetworkError(awsErr.OrigErr())
+		}
+	}
+
+	return IsRetryableNetworkError(err)
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var errFlaky = errors.New("flaky")
+
+// Back end that fails the first number of calls of every operation
+type flakyBackend struct {
+	Backend
+	failures int
+	calls    int
+	data     []byte
+	uploaded []byte
+}
+
+func (f *flakyBackend) fail() bool {
+	f.calls++
+	return f.calls <= f.failures
+}
+
+func (f *flakyBackend) UploadWithReader(hash string, r io.Reader) error {
+	data, _ := ioutil.ReadAll(r)
+	if f.fail() {
+		return errFlaky
+	}
+	f.uploaded = data
+	return nil
+}
+
+// Writes half of the requested range before failing
+func (f *flakyBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+	end := offset + length
+	if f.fail() {
+		end = offset + length/2
+	}
+	w.Write(f.data[offset:end])
+	if end < offset+length {
+		return errFlaky
+	}
+	return nil
+}
+
+func (f *flakyBackend) List(prefix string) (<-chan string, <-chan error) {
+	keys := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(keys)
+		keys <- "aa"
+		if f.fail() {
+			errs <- errFlaky
+			return
+		}
+		keys <- "bb"
+	}()
+	return keys, errs
+}
+
+func (f *flakyBackend) Ping() error {
+	if f.fail() {
+		return errFlaky
+	}
+	return nil
+}
+
+func isFlaky(err error) bool { return err == errFlaky }
+
+func init() {
+	sleep = func(time.Duration) {}
+}
+
+func TestRetryUpload(t *testing.T) {
+
+	flaky := &flakyBackend{failures: 2}
+	client := MakeRetrying(flaky, MakeRetryPolicy(nil), isFlaky)
+
+	err := client.UploadWithReader("aa", bytes.NewReader([]byte("content")))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, flaky.calls)
+	assert.Equal(t, []byte("content"), flaky.uploaded)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+
+	flaky := &flakyBackend{failures: 10}
+	client := MakeRetrying(flaky, MakeRetryPolicy(&config.RetryObject{MaxAttempts: 3}), isFlaky)
+
+	err := client.Ping()
+	assert.Equal(t, errFlaky, err)
+	assert.Equal(t, 3, flaky.calls)
+
+	// Errors that are not retryable are returned immediately
+	flaky = &flakyBackend{failures: 10}
+	client = MakeRetrying(flaky, MakeRetryPolicy(nil), func(err error) bool { return false })
+
+	err = client.Ping()
+	assert.Equal(t, errFlaky, err)
+	assert.Equal(t, 1, flaky.calls)
+}
+
+func TestRetryDownloadRangeResumes(t *testing.T) {
+
+	flaky := &flakyBackend{failures: 1, data: []byte("0123456789")}
+	client := MakeRetrying(flaky, MakeRetryPolicy(nil), isFlaky)
+
+	var buf bytes.Buffer
+	err := client.DownloadRange("aa", 2, 8, &buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "23456789", buf.String())
+}
+
+func TestRetryListSkipsSentKeys(t *testing.T) {
+
+	flaky := &flakyBackend{failures: 1}
+	client := MakeRetrying(flaky, MakeRetryPolicy(nil), isFlaky)
+
+	keys, errs := client.List("")
+	list := []string{}
+	for key := range keys {
+		list = append(list, key)
+	}
+	assert.Nil(t, <-errs)
+	assert.Equal(t, []string{"aa", "bb"}, list)
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(0))
+	assert.Equal(t, 400*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, time.Second, policy.backoff(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := policy.backoff(1)
+		assert.True(t, d >= 100*time.Millisecond && d <= 200*time.Millisecond)
+	}
+}
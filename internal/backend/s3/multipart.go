@@ -0,0 +1,315 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package s3
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Limits of S3 for multipart uploads (all parts but the last have the minimum size at least)
+const partSizeMinimum = 5 * 1024 * 1024
+const partsMaximum = 10000
+
+// Memory for buffering the parts that are uploaded in parallel (bounds the number of parallel parts)
+const partBuffersMaximum = 32 * 1024 * 1024
+
+// Store for the upload IDs of multipart uploads in progress, so that interrupted uploads can be resumed
+type UploadStore interface {
+	GetMultipartUpload(key string) (uploadId string, found bool, err error)
+	AddMultipartUpload(key, uploadId string) error
+	RemoveMultipartUpload(key string) error
+}
+
+// Get the size of the parts for an object, which is a multiple of the alignment (so that parts map to leaves)
+func partSize(size, align int64) int64 {
+
+	if align <= 0 {
+		align = 1
+	}
+	min := int64(partSizeMinimum)
+	if perPart := (size + partsMaximum - 1) / partsMaximum; perPart > min {
+		min = perPart
+	}
+	return (min + align - 1) / align * align
+}
+
+// Get the number of parts to upload in parallel, so that the buffers of the parts fit in memory
+func partWorkers(concurrency int, ps int64) int {
+
+	if max := int(partBuffersMaximum / ps); concurrency > max {
+		concurrency = max
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return concurrency
+}
+
+// Check whether an upload cannot be resumed: the upload is gone or its parts do not fit
+// (as opposed to transient or network errors, after which the upload can be resumed)
+func isPermanentUploadError(err error) bool {
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "NoSuchUpload", "InvalidPart", "InvalidPartOrder", "EntityTooSmall":
+			return true
+		}
+	}
+	return false
+}
+
+// Key under which the upload ID of an object is stored
+func (c *Client) uploadKey(hash string) string {
+
+	return c.Endpoint + "/" + c.Bucket + "/" + hash
+}
+
+// Upload an object in parts in parallel. An upload that fails is left in progress, so that calling
+// again resumes it by uploading the missing parts only (use AbortMultipart to give up instead).
+// Only an upload that cannot be resumed is aborted on failure.
+func (c *Client) UploadMultipart(hash string, r io.ReaderAt, size, align int64, concurrency int, request func()) error {
+
+	if request == nil {
+		request = func() {}
+	}
+
+	ps := partSize(size, align)
+	if size <= ps {
+		request()
+		return c.UploadWithReader(hash, io.NewSectionReader(r, 0, size))
+	}
+	numParts := int((size + ps - 1) / ps)
+
+	svc := s3.New(session.New(), c.getAwsConfig())
+	uploadId, completed, err := c.startMultipart(svc, hash, size, ps, request)
+	if err != nil {
+		return err
+	}
+	if completed == nil {
+		completed = make([]*s3.CompletedPart, numParts)
+	}
+
+	concurrency = partWorkers(concurrency, ps)
+	parts := make(chan int)
+	errs := make(chan error, concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf []byte
+			for part := range parts {
+				if buf == nil {
+					buf = make([]byte, ps)
+				}
+
+				// Read the whole part at once (so that every leaf is read only once)
+				offset := int64(part) * ps
+				length := size - offset
+				if length > ps {
+					length = ps
+				}
+				n, err := r.ReadAt(buf[:length], offset)
+				if int64(n) == length {
+					err = nil
+				} else if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				request()
+				result, err := svc.UploadPart(&s3.UploadPartInput{
+					Body:       bytes.NewReader(buf[:length]),
+					Bucket:     aws.String(c.Bucket),
+					Key:        aws.String(hash),
+					PartNumber: aws.Int64(int64(part + 1)),
+					UploadId:   aws.String(uploadId),
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				completed[part] = &s3.CompletedPart{ETag: result.ETag, PartNumber: aws.Int64(int64(part + 1))}
+			}
+		}()
+	}
+
+loop:
+	for part := range completed {
+		if completed[part] != nil {
+			continue // Uploaded before
+		}
+		select {
+		case parts <- part:
+		case err = <-errs:
+			break loop
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if err == nil && len(errs) > 0 {
+		err = <-errs
+	}
+	if err == nil {
+		request()
+		_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(c.Bucket),
+			Key:             aws.String(hash),
+			UploadId:        aws.String(uploadId),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+		})
+	}
+	if err != nil {
+		if c.Uploads == nil || isPermanentUploadError(err) {
+			// Upload cannot be resumed (without knowing its ID)
+			request()
+			c.abortUpload(svc, hash, uploadId)
+			c.forgetMultipart(hash)
+		}
+		return err
+	}
+
+	return c.forgetMultipart(hash)
+}
+
+// Resume the upload in progress for an object (returning the parts that are uploaded
+// already, in order until the first missing part) or create a new upload
+func (c *Client) startMultipart(svc *s3.S3, hash string, size, ps int64, request func()) (string, []*s3.CompletedPart, error) {
+
+	if c.Uploads != nil {
+		uploadId, found, err := c.Uploads.GetMultipartUpload(c.uploadKey(hash))
+		if err != nil {
+			return "", nil, err
+		}
+		if found {
+			request()
+			completed, err := c.listParts(svc, hash, uploadId, size, ps)
+			if err == nil {
+				return uploadId, completed, nil
+			}
+			if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchUpload" {
+				return "", nil, err
+			}
+			// Upload has expired or was aborted, so start over
+		}
+	}
+
+	request()
+	result, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(hash),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	uploadId := aws.StringValue(result.UploadId)
+
+	if c.Uploads != nil {
+		err = c.Uploads.AddMultipartUpload(c.uploadKey(hash), uploadId)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return uploadId, nil, nil
+}
+
+// List the parts of an upload in progress, skipping parts of a different size (eg. after changing the leaf size)
+func (c *Client) listParts(svc *s3.S3, hash, uploadId string, size, ps int64) ([]*s3.CompletedPart, error) {
+
+	numParts := int((size + ps - 1) / ps)
+	completed := make([]*s3.CompletedPart, numParts)
+
+	err := svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(c.Bucket),
+		Key:      aws.String(hash),
+		UploadId: aws.String(uploadId),
+	}, func(page *s3.ListPartsOutput, more bool) bool {
+		for _, p := range page.Parts {
+			part := int(aws.Int64Value(p.PartNumber)) - 1
+			if part < 0 || part >= numParts {
+				continue
+			}
+			length := size - int64(part)*ps
+			if length > ps {
+				length = ps
+			}
+			if aws.Int64Value(p.Size) == length {
+				completed[part] = &s3.CompletedPart{ETag: p.ETag, PartNumber: p.PartNumber}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return completed, nil
+}
+
+// Abort the upload in progress for an object (removing the parts uploaded so far). Uploads
+// are only aborted here when their ID is stored, otherwise a failed upload is aborted directly
+func (c *Client) AbortMultipart(hash string) error {
+
+	if c.Uploads == nil {
+		return nil
+	}
+	uploadId, found, err := c.Uploads.GetMultipartUpload(c.uploadKey(hash))
+	if err != nil || !found {
+		return err
+	}
+
+	err = c.abortUpload(s3.New(session.New(), c.getAwsConfig()), hash, uploadId)
+	if err != nil {
+		return err
+	}
+
+	return c.forgetMultipart(hash)
+}
+
+func (c *Client) abortUpload(svc *s3.S3, hash, uploadId string) error {
+
+	_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.Bucket),
+		Key:      aws.String(hash),
+		UploadId: aws.String(uploadId),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchUpload" {
+		return nil // Gone already
+	}
+	return err
+}
+
+func (c *Client) forgetMultipart(hash string) error {
+
+	if c.Uploads == nil {
+		return nil
+	}
+	return c.Uploads.RemoveMultipartUpload(c.uploadKey(hash))
+}
@@ -18,7 +18,9 @@ package s3
 
 import (
 	"io"
+	"fmt"
 	"errors"
+	"strings"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -26,15 +28,16 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/s3git/s3git-go/internal/config"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	Bucket     string
-	Region     string
-	AccessKey  string
-	SecretKey  string
-	Endpoint   string
+	Bucket      string
+	Region      string
+	Credentials *credentials.Credentials
+	Endpoint    string
+	Uploads     UploadStore // Multipart uploads are resumable when set
 }
 
 func MakeClient(remote config.RemoteObject) *Client {
@@ -42,7 +45,7 @@ func MakeClient(remote config.RemoteObject) *Client {
 	return &Client{
 		Bucket: remote.S3Bucket,
 		Region: remote.S3Region,
-		AccessKey: remote.S3AccessKey, SecretKey: remote.S3SecretKey,
+		Credentials: config.NewCredentials(remote),
 		Endpoint: remote.S3Endpoint}
 }
 
@@ -71,15 +74,10 @@ func (c *Client) VerifyHash(hash string) (bool, error) {
 		Key:    aws.String(hash),
 	})
 	if err != nil {
-		if _, ok := err.(awserr.Error); ok {
-			if reqErr, ok := err.(awserr.RequestFailure); ok {
-				// A service error occurred
-				if reqErr.StatusCode() == 404 {
-					return false, nil
-				}
-			}
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == 404 {
+			return false, nil
 		}
-		return false, errors.New("Failed to get head of object")
+		return false, err
 	}
 
 	return true, nil
@@ -100,31 +98,145 @@ func (c *Client) DownloadWithWriter(hash string, w io.WriterAt) error {
 	return nil
 }
 
-// List with a prefix string in S3
-func (c *Client) List(prefix string, action func(key string)) ([]string, error) {
+// Download a range of a file from S3 (may return less when the range extends beyond the end)
+func (c *Client) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	svc := s3.New(session.New(), c.getAwsConfig())
+	result, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(hash),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return err
+	}
+	defer result.Body.Close()
+
+	_, err = io.Copy(w, result.Body)
+	if err != nil {
+		return err
+	}
 
-	lister := lister{Client: c, action: action}
+	return nil
+}
 
-	client := s3.New(session.New(), c.getAwsConfig())
-	params := &s3.ListObjectsInput{Bucket: &c.Bucket, Prefix: &prefix}
-	client.ListObjectsPages(params, lister.eachPage)
+// Check access to the bucket (with the credentials and endpoint of the client)
+func (c *Client) Ping() error {
 
-	result := make([]string, 0)
+	// A missing object is fine, any other error is not
+	_, err := c.VerifyHash(pingKey)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to access bucket %s: %v", c.Bucket, err))
+	}
 
-	return result, nil
+	return nil
 }
 
-type lister struct {
-	*Client
-	action func(key string)
+// Key for a non existing object used for pinging
+var pingKey = strings.Repeat("0", 128)
+
+// Get the size of an object in S3
+func (c *Client) Stat(hash string) (int64, bool, error) {
+
+	svc := s3.New(session.New(), c.getAwsConfig())
+	result, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(hash),
+	})
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == 404 {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	return aws.Int64Value(result.ContentLength), true, nil
 }
 
-func (l *lister) eachPage(page *s3.ListObjectsOutput, more bool) bool {
-	for _, obj := range page.Contents {
-		l.action(*obj.Key)
+// Number of objects that are checked in parallel
+const existsRoutines = 16
+
+// Verify the existence of multiple hashes in S3
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	exists := make([]bool, len(hashes))
+	indices := make(chan int)
+	errs := make(chan error, existsRoutines)
+
+	var wg sync.WaitGroup
+	for i := 0; i < existsRoutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				var err error
+				exists[index], err = c.VerifyHash(hashes[index])
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+loop:
+	for index := range hashes {
+		select {
+		case indices <- index:
+		case err = <-errs:
+			break loop
+		}
 	}
+	close(indices)
+	wg.Wait()
+
+	if err == nil && len(errs) > 0 {
+		err = <-errs
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return exists, nil
+}
+
+// Delete an object in S3
+func (c *Client) Delete(hash string) error {
+
+	svc := s3.New(session.New(), c.getAwsConfig())
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(hash),
+	})
+
+	return err
+}
+
+// List with a prefix string in S3
+func (c *Client) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		client := s3.New(session.New(), c.getAwsConfig())
+		params := &s3.ListObjectsInput{Bucket: &c.Bucket, Prefix: &prefix}
+		err := client.ListObjectsPages(params, func(page *s3.ListObjectsOutput, more bool) bool {
+			for _, obj := range page.Contents {
+				keys <- *obj.Key
+			}
+			return true
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
 
-	return true
+	return keys, errs
 }
 
 func (c *Client) GetPresignedUrl(key string) (string, error) {
@@ -141,7 +253,7 @@ func (c *Client) GetPresignedUrl(key string) (string, error) {
 func (c *Client) getAwsConfig() *aws.Config {
 
 	s3Config := &aws.Config{
-		Credentials: credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, ""),
+		Credentials: c.Credentials,
 		Region: aws.String(c.Region)}
 
 	if c.Endpoint != "" {
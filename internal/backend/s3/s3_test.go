@@ -0,0 +1,168 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartSize(t *testing.T) {
+
+	// Parts are a multiple of the leaf size and at least the minimum part size
+	assert.Equal(t, int64(partSizeMinimum), partSize(100*1024*1024, 5*1024*1024))
+	assert.Equal(t, int64(6*1024*1024), partSize(100*1024*1024, 3*1024*1024))
+	assert.Equal(t, int64(5244000), partSize(100*1024*1024, 3000))
+
+	// Parts get larger for objects that would otherwise need too many parts
+	size := int64(partsMaximum) * 8 * 1024 * 1024
+	ps := partSize(size, 5*1024*1024)
+	assert.Equal(t, int64(10*1024*1024), ps)
+	assert.True(t, (size+ps-1)/ps <= partsMaximum)
+}
+
+func TestPartWorkers(t *testing.T) {
+
+	// Buffers of parts uploaded in parallel are bounded in total
+	assert.Equal(t, 6, partWorkers(8, partSizeMinimum))
+	assert.Equal(t, 2, partWorkers(2, partSizeMinimum))
+	assert.Equal(t, 1, partWorkers(8, 64*1024*1024))
+}
+
+func TestPermanentUploadError(t *testing.T) {
+
+	// Only uploads that are gone or have parts that do not fit are aborted
+	assert.True(t, isPermanentUploadError(awserr.New("NoSuchUpload", "gone", nil)))
+	assert.True(t, isPermanentUploadError(awserr.New("InvalidPart", "size", nil)))
+	assert.False(t, isPermanentUploadError(awserr.New("RequestTimeout", "slow", nil)))
+	assert.False(t, isPermanentUploadError(errors.New("connection reset by peer")))
+}
+
+type memoryUploadStore struct {
+	mutex   sync.Mutex
+	uploads map[string]string
+}
+
+func (m *memoryUploadStore) GetMultipartUpload(key string) (string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	uploadId, found := m.uploads[key]
+	return uploadId, found, nil
+}
+
+func (m *memoryUploadStore) AddMultipartUpload(key, uploadId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uploads[key] = uploadId
+	return nil
+}
+
+func (m *memoryUploadStore) RemoveMultipartUpload(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.uploads, key)
+	return nil
+}
+
+// Reader that fails reading beyond a limit and records the offsets that are read
+type failingReaderAt struct {
+	data    []byte
+	limit   int64
+	mutex   sync.Mutex
+	offsets []int64
+}
+
+func (f *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	f.mutex.Lock()
+	f.offsets = append(f.offsets, off)
+	f.mutex.Unlock()
+	if off+int64(len(p)) > f.limit {
+		return 0, errors.New("interrupted")
+	}
+	return bytes.NewReader(f.data).ReadAt(p, off)
+}
+
+// Get a client for a local S3 compatible server (eg. minio), set S3GIT_TEST_S3_ENDPOINT,
+// S3GIT_TEST_S3_BUCKET, S3GIT_TEST_S3_ACCESS_KEY and S3GIT_TEST_S3_SECRET_KEY to run
+func testClient(t *testing.T) *Client {
+
+	endpoint := os.Getenv("S3GIT_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("No local S3 compatible server configured (S3GIT_TEST_S3_ENDPOINT)")
+	}
+
+	client := MakeClient(config.RemoteObject{Type: config.REMOTE_S3, S3Bucket: os.Getenv("S3GIT_TEST_S3_BUCKET"),
+		S3AccessKey: os.Getenv("S3GIT_TEST_S3_ACCESS_KEY"), S3SecretKey: os.Getenv("S3GIT_TEST_S3_SECRET_KEY"), S3Endpoint: endpoint})
+	client.Uploads = &memoryUploadStore{uploads: make(map[string]string)}
+	return client
+}
+
+func TestUploadMultipartResumes(t *testing.T) {
+
+	client := testClient(t)
+	uploads := client.Uploads.(*memoryUploadStore)
+
+	data := make([]byte, 12*1024*1024)
+	rand.Read(data)
+	hash := strings.Repeat("a1", 64)
+	defer client.Delete(hash)
+
+	// Interrupt the upload after the first part (of three)
+	interrupted := &failingReaderAt{data: data, limit: partSizeMinimum}
+	err := client.UploadMultipart(hash, interrupted, int64(len(data)), 1024*1024, 1, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(uploads.uploads), "Expected upload in progress")
+
+	// Resume without uploading the first part again
+	resumed := &failingReaderAt{data: data, limit: int64(len(data))}
+	err = client.UploadMultipart(hash, resumed, int64(len(data)), 1024*1024, 2, nil)
+	assert.Nil(t, err)
+	assert.NotContains(t, resumed.offsets, int64(0))
+	assert.Equal(t, 0, len(uploads.uploads))
+
+	var buf bytes.Buffer
+	assert.Nil(t, client.DownloadRange(hash, 0, int64(len(data)), &buf))
+	assert.Equal(t, data, buf.Bytes())
+}
+
+func TestUploadMultipartAbort(t *testing.T) {
+
+	client := testClient(t)
+	uploads := client.Uploads.(*memoryUploadStore)
+
+	data := make([]byte, 12*1024*1024)
+	hash := strings.Repeat("a2", 64)
+
+	err := client.UploadMultipart(hash, &failingReaderAt{data: data, limit: partSizeMinimum}, int64(len(data)), 1024*1024, 1, nil)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, client.AbortMultipart(hash))
+	assert.Equal(t, 0, len(uploads.uploads))
+
+	_, found, err := client.Stat(hash)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
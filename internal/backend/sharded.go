@@ -0,0 +1,176 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"hash/fnv"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Back end that spreads objects over multiple back ends by hash prefix (so that
+// the request rate per bucket stays within limits). The number of shards must
+// not change once objects have been pushed.
+type shardedBackend struct {
+	shards []Backend
+}
+
+func MakeSharded(shards []Backend) Backend {
+
+	return &shardedBackend{shards: shards}
+}
+
+// Offset of the hex digits that route an object to a shard, which skips the leading digits
+// that all prefix objects have in common (so that prefix objects are spread as well)
+const shardRouteOffset = 8
+
+// Get the shard for an object based on 4 hex digits of the hash (names that are not
+// hashes, such as the index, are routed on a hash of the name instead)
+func (s *shardedBackend) shard(hash string) Backend {
+
+	if len(hash) >= shardRouteOffset+4 {
+		route, err := strconv.ParseUint(hash[shardRouteOffset:shardRouteOffset+4], 16, 16)
+		if err == nil {
+			return s.shards[int(route)%len(s.shards)]
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(hash))
+	return s.shards[int(h.Sum32()%uint32(len(s.shards)))]
+}
+
+func (s *shardedBackend) UploadWithReader(hash string, r io.Reader) error {
+	return s.shard(hash).UploadWithReader(hash, r)
+}
+
+func (s *shardedBackend) DownloadWithWriter(hash string, w io.WriterAt) error {
+	return s.shard(hash).DownloadWithWriter(hash, w)
+}
+
+func (s *shardedBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+	return s.shard(hash).DownloadRange(hash, offset, length, w)
+}
+
+func (s *shardedBackend) VerifyHash(hash string) (bool, error) {
+	return s.shard(hash).VerifyHash(hash)
+}
+
+func (s *shardedBackend) Stat(hash string) (int64, bool, error) {
+	return s.shard(hash).Stat(hash)
+}
+
+func (s *shardedBackend) Delete(hash string) error {
+	return s.shard(hash).Delete(hash)
+}
+
+// Check existence per shard in parallel
+func (s *shardedBackend) ExistsMany(hashes []string) ([]bool, error) {
+
+	indices := make(map[Backend][]int)
+	for i, hash := range hashes {
+		shard := s.shard(hash)
+		indices[shard] = append(indices[shard], i)
+	}
+
+	exists := make([]bool, len(hashes))
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var err error
+	for shard, idx := range indices {
+		wg.Add(1)
+		go func(shard Backend, idx []int) {
+			defer wg.Done()
+
+			shardHashes := make([]string, len(idx))
+			for i, j := range idx {
+				shardHashes[i] = hashes[j]
+			}
+			shardExists, e := shard.ExistsMany(shardHashes)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if e != nil {
+				if err == nil {
+					err = e
+				}
+				return
+			}
+			for i, j := range idx {
+				exists[j] = shardExists[i]
+			}
+		}(shard, idx)
+	}
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+	return exists, nil
+}
+
+// List all shards in parallel and merge the keys
+func (s *shardedBackend) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	shardErrs := make(chan error, len(s.shards))
+	for _, shard := range s.shards {
+		wg.Add(1)
+		go func(shard Backend) {
+			defer wg.Done()
+
+			ks, es := shard.List(prefix)
+			for key := range ks {
+				keys <- key
+			}
+			shardErrs <- <-es
+		}(shard)
+	}
+
+	go func() {
+		wg.Wait()
+		close(keys)
+		close(shardErrs)
+
+		var err error
+		for e := range shardErrs {
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+		errs <- err
+		close(errs)
+	}()
+
+	return keys, errs
+}
+
+// Check that all shards are accessible
+func (s *shardedBackend) Ping() error {
+
+	for _, shard := range s.shards {
+		if err := shard.Ping(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
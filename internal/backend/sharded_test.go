@@ -0,0 +1,132 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/s3git/s3git-go/internal/backend/file"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharded(t *testing.T) {
+
+	dirs := []string{}
+	shards := []Backend{}
+	for i := 0; i < 3; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-sharded-")
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, dir)
+		shards = append(shards, file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir}))
+	}
+	client := MakeSharded(shards)
+	assert.Nil(t, client.Ping())
+
+	// 0x0000, 0x0001 and 0x0002 (following the leading digits) map to the first, second and third shard
+	hashes := []string{}
+	for _, prefix := range []string{"0000", "0001", "0002", "0003"} {
+		hash := strings.Repeat("ab", 4) + prefix + strings.Repeat("ab", 58)
+		hashes = append(hashes, hash)
+		assert.Nil(t, client.UploadWithReader(hash, strings.NewReader("hello "+prefix)))
+	}
+
+	for i, shard := range shards {
+		exists, err := shard.ExistsMany(hashes)
+		assert.Nil(t, err)
+		assert.Equal(t, []bool{i == 0, i == 1, i == 2, i == 0}, exists)
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, client.DownloadRange(hashes[2], 0, 100, &buf))
+	assert.Equal(t, "hello 0002", buf.String())
+
+	missing := strings.Repeat("ab", 4) + "0004" + strings.Repeat("cd", 58)
+	exists, err := client.ExistsMany([]string{hashes[3], missing, hashes[1]})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, false, true}, exists)
+
+	keys, errs := client.List("abab")
+	list := []string{}
+	for key := range keys {
+		list = append(list, key)
+	}
+	assert.Nil(t, <-errs)
+	sort.Strings(list)
+	assert.Equal(t, hashes, list)
+
+	assert.Nil(t, client.Delete(hashes[1]))
+	_, found, err := client.Stat(hashes[1])
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestShardedRouting(t *testing.T) {
+
+	shards := []Backend{}
+	for i := 0; i < 4; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-sharded-")
+		defer os.RemoveAll(dir)
+		shards = append(shards, file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: dir}))
+	}
+	client := MakeSharded(shards).(*shardedBackend)
+
+	// Prefix objects share their leading digits, yet are spread over the shards
+	used := make(map[Backend]bool)
+	for _, digits := range []string{"1234", "5678", "9abc", "def0", "0123", "4567"} {
+		used[client.shard("0000000" + "0" + digits + strings.Repeat("ab", 58))] = true
+	}
+	assert.True(t, len(used) > 1, "Prefix objects are routed to a single shard")
+
+	// Names that are not hashes are routed deterministically, but not all to the same shard
+	used = make(map[Backend]bool)
+	for _, name := range []string{"s3git-index", "index.html", "a", "b", "c", "d", "e", "f"} {
+		assert.Equal(t, client.shard(name), client.shard(name))
+		used[client.shard(name)] = true
+	}
+	assert.True(t, len(used) > 1, "Names are routed to a single shard")
+}
+
+func TestShardsAreRetriedAndLimited(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-sharded-")
+	defer os.RemoveAll(dir)
+
+	remote := config.RemoteObject{Name: "sharded", Type: config.REMOTE_SHARDED, Retry: &config.RetryObject{MaxAttempts: 7}, Shards: []config.RemoteObject{
+		{Name: "sharded-0", Type: config.REMOTE_S3, S3Bucket: "bucket", Limits: &config.LimitsObject{RequestsPerSecond: 100}},
+		{Name: "sharded-1", Type: config.REMOTE_FILE, FileDirectory: dir}}}
+	client, err := makeClient(remote)
+	assert.Nil(t, err)
+	shards := client.(*shardedBackend).shards
+
+	// Remote shard is retried (with the policy of the remote) and limited on its own
+	retrying, ok := shards[0].(*retryingBackend)
+	assert.True(t, ok, "Expected shard to be retried")
+	assert.Equal(t, 7, retrying.policy.MaxAttempts)
+	_, ok = retrying.Backend.(*limitedBackend)
+	assert.True(t, ok, "Expected shard to be limited")
+
+	// Local shard is not retried
+	_, ok = shards[1].(*retryingBackend)
+	assert.False(t, ok)
+	assert.Nil(t, retryableFor(remote), "Expected retries per shard only")
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/s3git/s3git-go/internal/kv"
+)
+
+const objectTypeLeaf = "leaf"
+
+// Back end that stores small metadata objects (prefix, commit, tree and snapshot objects) on a
+// fast tier and blobs and leaves on a bulk tier. Objects of unknown type (eg. when mirroring)
+// are routed by size only. Reads try the tier that the object is expected on first.
+type tieredBackend struct {
+	metadata   Backend
+	bulk       Backend
+	maxSize    int64                    // Maximum size of objects on the metadata tier
+	objectType func(hash string) string // Empty when unknown
+}
+
+func MakeTiered(metadata, bulk Backend, maxSize int64, objectType func(hash string) string) Backend {
+
+	return &tieredBackend{metadata: metadata, bulk: bulk, maxSize: maxSize, objectType: objectType}
+}
+
+// Get the type of an object from the local key value store
+func objectTypeFromKV(hash string) string {
+
+	key, err := hex.DecodeString(hash)
+	if err != nil {
+		return ""
+	}
+	if _, objType, err := kv.GetLevel1(key); err == nil {
+		return objType
+	}
+	if _, found, _ := kv.GetLevel0Size(hash); found {
+		return objectTypeLeaf
+	}
+	return ""
+}
+
+// Get the tiers in the order in which to try to read an object
+func (t *tieredBackend) tiers(hash string) (Backend, Backend) {
+
+	switch t.objectType(hash) {
+	case kv.BLOB, objectTypeLeaf:
+		return t.bulk, t.metadata
+	default: // Metadata or unknown (which is likely a metadata object as leaves are read by range)
+		return t.metadata, t.bulk
+	}
+}
+
+func (t *tieredBackend) UploadWithReader(hash string, r io.Reader) error {
+
+	objType := t.objectType(hash)
+	if objType == kv.BLOB || objType == objectTypeLeaf {
+		return t.bulk.UploadWithReader(hash, r)
+	}
+
+	// Read up to the maximum size to find out whether the object is small enough
+	head, err := ioutil.ReadAll(io.LimitReader(r, t.maxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(head)) <= t.maxSize {
+		return t.metadata.UploadWithReader(hash, bytes.NewReader(head))
+	}
+
+	// Keep the reader seekable when possible (so that the upload can be retried)
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		if _, err := seeker.Seek(-int64(len(head)), io.SeekCurrent); err == nil {
+			return t.bulk.UploadWithReader(hash, seeker)
+		}
+	}
+	// Not seekable, so neither can the caller retry the upload with the same reader
+	return t.bulk.UploadWithReader(hash, io.MultiReader(bytes.NewReader(head), r))
+}
+
+func (t *tieredBackend) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	first, second := t.tiers(hash)
+	err := first.DownloadWithWriter(hash, w)
+	if err != nil {
+		if second.DownloadWithWriter(hash, w) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (t *tieredBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	first, second := t.tiers(hash)
+
+	// Only fall back when nothing was written yet
+	cw := &countingWriter{w: w}
+	err := first.DownloadRange(hash, offset, length, cw)
+	if err != nil && cw.n == 0 {
+		if second.DownloadRange(hash, offset, length, w) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (t *tieredBackend) VerifyHash(hash string) (bool, error) {
+
+	first, second := t.tiers(hash)
+	verified, err := first.VerifyHash(hash)
+	if err != nil || verified {
+		return verified, err
+	}
+	return second.VerifyHash(hash)
+}
+
+func (t *tieredBackend) Stat(hash string) (int64, bool, error) {
+
+	first, second := t.tiers(hash)
+	size, exists, err := first.Stat(hash)
+	if err != nil || exists {
+		return size, exists, err
+	}
+	return second.Stat(hash)
+}
+
+func (t *tieredBackend) ExistsMany(hashes []string) ([]bool, error) {
+
+	exists, err := t.metadata.ExistsMany(hashes)
+	if err != nil {
+		return nil, err
+	}
+	existsBulk, err := t.bulk.ExistsMany(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range exists {
+		exists[i] = exists[i] || existsBulk[i]
+	}
+	return exists, nil
+}
+
+// Delete from both tiers, also when deleting from one of them fails
+func (t *tieredBackend) Delete(hash string) error {
+
+	errMetadata := t.metadata.Delete(hash)
+	errBulk := t.bulk.Delete(hash)
+	if errMetadata != nil && errBulk != nil {
+		return errors.New(fmt.Sprintf("Failed to delete from metadata tier: %s, and from bulk tier: %s", errMetadata, errBulk))
+	} else if errMetadata != nil {
+		return errMetadata
+	}
+	return errBulk
+}
+
+// List both tiers, metadata tier first. Keys of the metadata tier are remembered so that
+// objects that are present on both tiers are only listed once (the metadata tier is small)
+func (t *tieredBackend) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		listed := make(map[string]bool)
+		ks, es := t.metadata.List(prefix)
+		for key := range ks {
+			listed[key] = true
+			keys <- key
+		}
+		if err := <-es; err != nil {
+			errs <- err
+			return
+		}
+
+		ks, es = t.bulk.List(prefix)
+		for key := range ks {
+			if !listed[key] {
+				keys <- key
+			}
+		}
+		errs <- <-es
+	}()
+
+	return keys, errs
+}
+
+func (t *tieredBackend) Ping() error {
+
+	err := t.metadata.Ping()
+	if err != nil {
+		return err
+	}
+	return t.bulk.Ping()
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/s3git/s3git-go/internal/backend/file"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/s3git/s3git-go/internal/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiered(t *testing.T) {
+
+	metadataDir, _ := ioutil.TempDir("", "s3git-tiered-metadata-")
+	defer os.RemoveAll(metadataDir)
+	bulkDir, _ := ioutil.TempDir("", "s3git-tiered-bulk-")
+	defer os.RemoveAll(bulkDir)
+
+	metadata := file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: metadataDir})
+	bulk := file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: bulkDir})
+
+	commit, blob, leaf := strings.Repeat("01", 64), strings.Repeat("02", 64), strings.Repeat("03", 64)
+	small, large := strings.Repeat("04", 64), strings.Repeat("05", 64)
+	types := map[string]string{commit: kv.COMMIT, blob: kv.BLOB, leaf: objectTypeLeaf}
+
+	client := MakeTiered(metadata, bulk, 16, func(hash string) string { return types[hash] })
+	assert.Nil(t, client.Ping())
+
+	// Blobs and leaves go to the bulk tier regardless of size, other objects depending on size
+	assert.Nil(t, client.UploadWithReader(commit, strings.NewReader("commit")))
+	assert.Nil(t, client.UploadWithReader(blob, strings.NewReader("blob")))
+	assert.Nil(t, client.UploadWithReader(leaf, strings.NewReader("leaf")))
+	assert.Nil(t, client.UploadWithReader(small, strings.NewReader("small object")))
+	assert.Nil(t, client.UploadWithReader(large, bytes.NewBufferString("object larger than the maximum")))
+
+	hashes := []string{commit, blob, leaf, small, large}
+	exists, err := metadata.ExistsMany(hashes)
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, false, false, true, false}, exists)
+	exists, err = bulk.ExistsMany(hashes)
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false, true, true, false, true}, exists)
+
+	exists, err = client.ExistsMany(append(hashes, strings.Repeat("06", 64)))
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, true, true, true, true, false}, exists)
+
+	// Reads fall back to the other tier
+	var buf bytes.Buffer
+	assert.Nil(t, client.DownloadRange(large, 0, 100, &buf))
+	assert.Equal(t, "object larger than the maximum", buf.String())
+	buf.Reset()
+	assert.Nil(t, client.DownloadRange(leaf, 1, 3, &buf))
+	assert.Equal(t, "eaf", buf.String())
+
+	size, found, err := client.Stat(small)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(len("small object")), size)
+
+	// Listing is done on both tiers, objects on both tiers are listed once
+	assert.Nil(t, bulk.UploadWithReader(commit, strings.NewReader("commit")))
+	keys, errs := client.List("0")
+	list := []string{}
+	for key := range keys {
+		list = append(list, key)
+	}
+	assert.Nil(t, <-errs)
+	sort.Strings(list)
+	assert.Equal(t, []string{commit, blob, leaf, small, large}, list)
+
+	assert.Nil(t, client.Delete(large))
+	_, found, err = client.Stat(large)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	// Deleting removes the object from both tiers
+	assert.Nil(t, client.Delete(commit))
+	exists, err = client.ExistsMany([]string{commit})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false}, exists)
+}
+
+func TestTieredDeleteFromBothTiers(t *testing.T) {
+
+	bulkDir, _ := ioutil.TempDir("", "s3git-tiered-bulk-")
+	defer os.RemoveAll(bulkDir)
+
+	bulk := file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: bulkDir})
+	blob := strings.Repeat("02", 64)
+	client := MakeTiered(unavailableBackend{}, bulk, 16, func(hash string) string { return kv.BLOB })
+
+	// The bulk tier is deleted from even though the metadata tier fails
+	assert.Nil(t, bulk.UploadWithReader(blob, strings.NewReader("blob")))
+	assert.Equal(t, errUnavailable, client.Delete(blob))
+	exists, err := bulk.ExistsMany([]string{blob})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false}, exists)
+
+	// Errors of both tiers are combined
+	err = MakeTiered(unavailableBackend{}, unavailableBackend{}, 16, objectTypeFromKV).Delete(blob)
+	assert.Contains(t, err.Error(), "metadata tier")
+	assert.Contains(t, err.Error(), "bulk tier")
+
+	// Listing fails when a tier fails
+	keys, errs := client.List("")
+	for range keys {
+	}
+	assert.Equal(t, errUnavailable, <-errs)
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package web
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+// Name of the index (listing the prefix objects) that is published along with the objects
+const IndexName = "s3git-index"
+
+type Client struct {
+	Url    string
+	client *http.Client
+
+	indexOnce sync.Once
+	index     []string
+	indexErr  error
+}
+
+func MakeClient(remote config.RemoteObject) *Client {
+
+	return &Client{
+		Url: strings.TrimRight(remote.HttpUrl, "/"),
+		client: http.DefaultClient}
+}
+
+// Uploading is not supported for a static web server
+func (c *Client) UploadWithReader(hash string, r io.Reader) error {
+
+	return errors.New(fmt.Sprintf("Remote is read-only: %s", c.Url))
+}
+
+// Deleting is not supported for a static web server
+func (c *Client) Delete(hash string) error {
+
+	return errors.New(fmt.Sprintf("Remote is read-only: %s", c.Url))
+}
+
+// Verify the existence of a hash
+func (c *Client) VerifyHash(hash string) (bool, error) {
+
+	_, exists, err := c.Stat(hash)
+	return exists, err
+}
+
+// Get the size of a file
+func (c *Client) Stat(hash string) (int64, bool, error) {
+
+	resp, err := c.client.Head(c.Url + "/" + hash)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, true, nil
+	case http.StatusNotFound:
+		return 0, false, nil
+	}
+
+	return 0, false, newStatusError(resp, "Unexpected status for %s: %s", hash)
+}
+
+// Verify the existence of multiple hashes
+func (c *Client) ExistsMany(hashes []string) ([]bool, error) {
+
+	exists := make([]bool, len(hashes))
+	for i, hash := range hashes {
+		var err error
+		exists[i], err = c.VerifyHash(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exists, nil
+}
+
+// Download a file
+func (c *Client) DownloadWithWriter(hash string, w io.WriterAt) error {
+
+	resp, err := c.get(hash, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, 32*1024)
+	var offset int64
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			_, ew := w.WriteAt(buf[:n], offset)
+			if ew != nil {
+				return ew
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Download a range of a file (may return less when the range extends beyond the end)
+func (c *Client) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+
+	if length <= 0 {
+		return nil
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length < math.MaxInt64-offset {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	resp, err := c.get(hash, byteRange)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil // Range starts beyond the end
+	case http.StatusOK:
+		// Server does not support ranges, skip up to the offset
+		_, err = io.CopyN(ioutil.Discard, r, offset)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(w, io.LimitReader(r, length))
+	return err
+}
+
+func (c *Client) get(hash, byteRange string) (*http.Response, error) {
+
+	req, err := http.NewRequest("GET", c.Url+"/"+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	return nil, newStatusError(resp, "Failed to get %s: %s", hash)
+}
+
+// Check that the index is published
+func (c *Client) Ping() error {
+
+	verified, err := c.VerifyHash(IndexName)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return errors.New(fmt.Sprintf("No index found at %s", c.Url))
+	}
+
+	return nil
+}
+
+// List with a prefix string (from the published index)
+func (c *Client) List(prefix string) (<-chan string, <-chan error) {
+
+	keys := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(keys)
+
+		c.indexOnce.Do(func() {
+			c.index, c.indexErr = c.getIndex()
+		})
+		if c.indexErr != nil {
+			errs <- c.indexErr
+			return
+		}
+
+		for _, key := range c.index {
+			if strings.HasPrefix(key, prefix) {
+				keys <- key
+			}
+		}
+	}()
+
+	return keys, errs
+}
+
+// Get the index once (all prefixes are listed in parallel)
+func (c *Client) getIndex() ([]string, error) {
+
+	resp, err := c.get(IndexName, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	index := []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			index = append(index, key)
+		}
+	}
+
+	return index, scanner.Err()
+}
+
+// Error for an unexpected HTTP status
+type statusError struct {
+	statusCode int
+	msg        string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func newStatusError(resp *http.Response, format string, arg string) error {
+	return &statusError{statusCode: resp.StatusCode, msg: fmt.Sprintf(format, arg, resp.Status)}
+}
+
+// Server errors, throttling and timeouts are worth retrying
+func IsRetryableStatus(err error) bool {
+
+	if se, ok := err.(*statusError); ok {
+		return se.statusCode >= 500 || se.statusCode == http.StatusTooManyRequests || se.statusCode == http.StatusRequestTimeout
+	}
+	return false
+}
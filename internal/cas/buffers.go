@@ -0,0 +1,81 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cas
+
+import (
+	"sync"
+
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+// Pool of leaf buffers that is shared between writers and hashing streams
+var leafBufferPool sync.Pool
+
+// Bounded set of reusable buffers for leaves.
+//
+// Buffers are allocated on demand (so small objects only ever allocate a single buffer)
+// up to the maximum, after which get() blocks until a buffer is put back, which bounds
+// the number of leaves that are in flight. Buffers are obtained by a single go routine,
+// whereas any go routine can put them back.
+type leafBuffers struct {
+	free      chan []byte
+	allocated int
+}
+
+func makeLeafBuffers(max int) *leafBuffers {
+	return &leafBuffers{free: make(chan []byte, max)}
+}
+
+// Get a buffer of LeafSize bytes
+func (lb *leafBuffers) get() []byte {
+
+	select {
+	case b := <-lb.free:
+		return b
+	default:
+	}
+
+	if lb.allocated < cap(lb.free) {
+		lb.allocated++
+
+		if b, ok := leafBufferPool.Get().([]byte); ok && uint32(cap(b)) == config.Config.LeafSize {
+			return b[:cap(b)]
+		}
+		return make([]byte, config.Config.LeafSize)
+	}
+
+	return <-lb.free
+}
+
+// Return a buffer (never blocks as at most cap(free) buffers are allocated)
+func (lb *leafBuffers) put(b []byte) {
+	lb.free <- b[:cap(b)]
+}
+
+// Hand all buffers back to the shared pool, buffers that are still in use are left to the GC
+func (lb *leafBuffers) release() {
+
+	for {
+		select {
+		case b := <-lb.free:
+			leafBufferPool.Put(b)
+		default:
+			lb.allocated = 0
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cas
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/golang/snappy"
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+// Compressed leaves start with a header consisting of a magic, the codec and the size
+// of the uncompressed contents. Hashes are always computed over the uncompressed contents.
+var leafHeaderMagic = []byte("\x00s3git")
+
+const leafHeaderSize = 6 + 1 + 4
+
+const (
+	codecNone    byte = 0
+	codecSnappy  byte = 1
+	codecDeflate byte = 2
+)
+
+// Get the codec for compressing leaves from the config
+func configCodec() byte {
+
+	switch config.Config.Compression {
+	case config.COMPRESSION_SNAPPY:
+		return codecSnappy
+	case config.COMPRESSION_DEFLATE:
+		return codecDeflate
+	default:
+		return codecNone
+	}
+}
+
+// Check whether a leaf is stored with a header
+func hasLeafHeader(data []byte) bool {
+	return len(data) >= leafHeaderSize && bytes.HasPrefix(data, leafHeaderMagic)
+}
+
+// Encode a leaf for storage, uncompressed leaves are returned as is (unless they happen to start with the magic)
+func encodeLeaf(chunk []byte, codec byte) ([]byte, error) {
+
+	var payload []byte
+	switch codec {
+	case codecNone:
+		if !bytes.HasPrefix(chunk, leafHeaderMagic) {
+			return chunk, nil
+		}
+		payload = chunk
+	case codecSnappy:
+		payload = snappy.Encode(nil, chunk)
+	case codecDeflate:
+		buf := new(bytes.Buffer)
+		fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		fw.Write(chunk)
+		err = fw.Close()
+		if err != nil {
+			return nil, err
+		}
+		payload = buf.Bytes()
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown codec: %d", codec))
+	}
+
+	// Do not store compressed when there is no gain
+	if codec != codecNone && len(payload)+leafHeaderSize >= len(chunk) {
+		return encodeLeaf(chunk, codecNone)
+	}
+
+	data := make([]byte, leafHeaderSize, leafHeaderSize+len(payload))
+	copy(data, leafHeaderMagic)
+	data[len(leafHeaderMagic)] = codec
+	binary.LittleEndian.PutUint32(data[len(leafHeaderMagic)+1:], uint32(len(chunk)))
+
+	return append(data, payload...), nil
+}
+
+// Decode a stored leaf, leaves without header are returned as is
+func decodeLeaf(data []byte) ([]byte, error) {
+
+	if !hasLeafHeader(data) {
+		return data, nil
+	}
+
+	size := decodedLeafSize(data)
+	payload := data[leafHeaderSize:]
+
+	var chunk []byte
+	switch data[len(leafHeaderMagic)] {
+	case codecNone:
+		chunk = payload
+	case codecSnappy:
+		var err error
+		chunk, err = snappy.Decode(make([]byte, size), payload)
+		if err != nil {
+			return nil, err
+		}
+	case codecDeflate:
+		var err error
+		chunk, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(payload)))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown codec for leaf: %d", data[len(leafHeaderMagic)]))
+	}
+
+	if uint32(len(chunk)) != size {
+		return nil, errors.New("Size of decoded leaf does not match size in header")
+	}
+
+	return chunk, nil
+}
+
+// Get the size of the uncompressed contents of a stored leaf
+func decodedLeafSize(data []byte) uint32 {
+
+	if !hasLeafHeader(data) {
+		return uint32(len(data))
+	}
+	return binary.LittleEndian.Uint32(data[len(leafHeaderMagic)+1:])
+}
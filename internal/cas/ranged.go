@@ -0,0 +1,246 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cas
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/s3git/s3git-go/internal/backend"
+)
+
+// Number of ranges to download in parallel (unless configured for the remote)
+const rangedDownloaders = 8
+
+// Download a leaf aligned range of a blob. Ranges are requested with one additional
+// byte so that it is known whether the leaf is the last node (ie. returned size <= LeafSize)
+func downloadLeafRange(hash string, leafNr int, client backend.Backend) ([]byte, error) {
+
+	leafSize := int64(config.Config.LeafSize)
+
+	buf := bytes.NewBuffer(make([]byte, 0, leafSize+1))
+	err := client.DownloadRange(hash, int64(leafNr)*leafSize, leafSize+1, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Pull a hydrated blob down to the cache in leaf aligned ranges in parallel, given that the
+// first range has been downloaded already. When the leaves of the blob are known, each leaf is
+// stored as soon as it matches its key (so it can be read before the other ranges are done).
+// Otherwise ranges are kept in a temporary directory until the leaves are verified to add up to
+// the hash of the blob, only then the leaves are stored. Returns nil (without error) on a
+// mismatch, in which case the blob needs to be downloaded as a whole.
+func pullHydratedInRanges(hash string, first []byte, leaves []Key, client backend.Backend, downloaders int) ([]byte, error) {
+
+	if len(leaves) > 0 {
+		return pullKnownLeavesInRanges(hash, first, leaves, client, downloaders)
+	}
+
+	leafSize := int(config.Config.LeafSize)
+
+	dir, err := ioutil.TempDir("", "pull-ranges-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var mutex sync.Mutex
+	lastLeaf := -1 // Unknown until a range is returned that is not followed by more data
+	nextLeaf := 1
+	var errRange error
+	errLeaf := -1
+
+	// Keep a leaf in the temporary directory and mark when it is the last one
+	storeRange := func(leafNr int, chunk []byte) error {
+
+		isLastNode := len(chunk) <= leafSize
+		if !isLastNode {
+			chunk = chunk[:leafSize]
+		} else if len(chunk) == 0 && leafNr > 0 {
+			// Previous leaf was the last node
+			return nil
+		}
+
+		leafKey := computeLeafKey(chunk, uint64(leafNr), isLastNode)
+		err := ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(leafNr)), chunk, 0600)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for len(leaves) <= leafNr {
+			leaves = append(leaves, Key{})
+		}
+		leaves[leafNr] = leafKey
+		if isLastNode && (lastLeaf == -1 || leafNr < lastLeaf) {
+			lastLeaf = leafNr
+		}
+		return err
+	}
+
+	err = storeRange(0, first)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < downloaders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// Claim the next leaf, unless the last leaf is known or an error occurred
+				mutex.Lock()
+				if (lastLeaf != -1 && nextLeaf > lastLeaf) || errRange != nil {
+					mutex.Unlock()
+					return
+				}
+				leafNr := nextLeaf
+				nextLeaf++
+				mutex.Unlock()
+
+				chunk, err := downloadLeafRange(hash, leafNr, client)
+				if err == nil {
+					err = storeRange(leafNr, chunk)
+				}
+				if err != nil {
+					// Errors beyond the end of the blob are expected (eg. for a range that cannot be satisfied)
+					mutex.Lock()
+					if errRange == nil || leafNr < errLeaf {
+						errRange, errLeaf = err, leafNr
+					}
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lastLeaf == -1 {
+		return nil, errRange
+	} else if errRange != nil && errLeaf <= lastLeaf {
+		return nil, errRange
+	}
+	leaves = leaves[:lastLeaf+1]
+
+	// Verify that the leaves make up the blob
+	rootStr, err := computeRootBlake2(leaves)
+	if err != nil {
+		return nil, err
+	}
+	if rootStr != hash {
+		return nil, nil
+	}
+
+	leafHashes := make([]byte, len(leaves)*KeySize)
+	for index, l := range leaves {
+		chunk, err := ioutil.ReadFile(filepath.Join(dir, strconv.Itoa(index)))
+		if err != nil {
+			return nil, err
+		}
+		err = storeLeaf(l, chunk, cacheDir)
+		if err != nil {
+			return nil, err
+		}
+		copy(leafHashes[index*KeySize:(index+1)*KeySize], l.object[:])
+	}
+	return leafHashes, nil
+}
+
+// Pull the known leaves of a hydrated blob in ranges, every leaf is verified against its key
+// and stored right away. Returns nil (without error) when a range does not match its leaf.
+func pullKnownLeavesInRanges(hash string, first []byte, leaves []Key, client backend.Backend, downloaders int) ([]byte, error) {
+
+	leafSize := int(config.Config.LeafSize)
+
+	// Verify a range against the key of its leaf and store the leaf when it matches
+	storeRange := func(leafNr int, chunk []byte) (bool, error) {
+
+		isLastNode := leafNr == len(leaves)-1
+		if isLastNode && len(chunk) > leafSize {
+			return false, nil
+		} else if !isLastNode {
+			if len(chunk) <= leafSize {
+				return false, nil
+			}
+			chunk = chunk[:leafSize]
+		}
+
+		if computeLeafKey(chunk, uint64(leafNr), isLastNode) != leaves[leafNr] {
+			return false, nil
+		}
+		return true, storeLeaf(leaves[leafNr], chunk, cacheDir)
+	}
+
+	matches, err := storeRange(0, first)
+	if err != nil || !matches {
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	nextLeaf := 1
+	mismatch := false
+	var errRange error
+
+	var wg sync.WaitGroup
+	for i := 0; i < downloaders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// Claim the next leaf, unless all leaves are claimed or a range failed
+				mutex.Lock()
+				if nextLeaf == len(leaves) || mismatch || errRange != nil {
+					mutex.Unlock()
+					return
+				}
+				leafNr := nextLeaf
+				nextLeaf++
+				mutex.Unlock()
+
+				matches := false
+				chunk, err := downloadLeafRange(hash, leafNr, client)
+				if err == nil {
+					matches, err = storeRange(leafNr, chunk)
+				}
+
+				mutex.Lock()
+				if err != nil && errRange == nil {
+					errRange = err
+				} else if err == nil && !matches {
+					mismatch = true
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if errRange != nil || mismatch {
+		return nil, errRange
+	}
+
+	leafHashes := make([]byte, len(leaves)*KeySize)
+	for index, l := range leaves {
+		copy(leafHashes[index*KeySize:(index+1)*KeySize], l.object[:])
+	}
+	return leafHashes, nil
+}
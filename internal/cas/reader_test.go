@@ -20,6 +20,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"strings"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"syscall"
 	"github.com/s3git/s3git-go/internal/config"
 )
 
@@ -57,3 +62,137 @@ func TestReadWithBiggerLeafSize(t *testing.T) {
 
 	assert.Equal(t, input, output, "Input and output are different")
 }
+
+func TestReadAtAndSeek(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	input := strings.Repeat("AbCdEfGhIjKlMnOpQrDtUvWxYz", 200)
+
+	rootKeyStr := writeTo(t, strings.NewReader(input))
+
+	cr, err := OpenReader(rootKeyStr)
+	assert.Nil(t, err)
+	defer cr.Close()
+
+	size, err := cr.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(input)), size, "Size is different")
+
+	cr.mutex.Lock()
+	second, err := cr.loadLeaf(1)
+	cr.mutex.Unlock()
+	assert.Nil(t, err)
+	assert.True(t, hasFixedSizeLeaves(cr.leaves, second), "Fixed size leaves not detected")
+
+	// Read across leaf boundary
+	p := make([]byte, 100)
+	n, err := cr.ReadAt(p, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, input[1000:1100], string(p), "Range is different")
+
+	// Read beyond end of blob
+	n, err = cr.ReadAt(p, size-10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, input[len(input)-10:], string(p[:n]), "Tail is different")
+
+	// Seek relative to end and read remainder
+	pos, err := cr.Seek(-2100, io.SeekEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, size-2100, pos)
+	tail, err := ioutil.ReadAll(cr)
+	assert.Nil(t, err)
+	assert.Equal(t, input[len(input)-2100:], string(tail), "Remainder is different")
+}
+
+func TestReadAtContentDefinedWithFixedConfig(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 64 * 1024
+	config.Config.RollingHashBits = 12
+	config.Config.RollingHashMin = 1024
+
+	data := make([]byte, 512*1024)
+	rand.New(rand.NewSource(42)).Read(data)
+	input := string(data)
+
+	cw := MakeWriter(BLOB)
+	io.Copy(cw, strings.NewReader(input))
+	rootKeyStr, leafHashes, _, err := cw.Flush()
+	assert.Nil(t, err)
+
+	// Reading must not depend on the chunking that is configured locally
+	config.Config.RollingHashBits = 0
+
+	cr, err := OpenReader(rootKeyStr)
+	assert.Nil(t, err)
+	defer cr.Close()
+
+	assert.Equal(t, len(leafHashes)/KeySize, len(cr.leaves))
+	assert.True(t, len(cr.leaves) > 2, "Expected multiple content defined leaves")
+	cr.mutex.Lock()
+	second, err := cr.loadLeaf(1)
+	cr.mutex.Unlock()
+	assert.Nil(t, err)
+	assert.False(t, hasFixedSizeLeaves(cr.leaves, second), "Content defined leaves detected as fixed size")
+
+	p := make([]byte, 5000)
+	for _, off := range []int64{0, 4000, int64(len(input)) / 2, int64(len(input)) - 5000} {
+		n, err := cr.ReadAt(p, off)
+		assert.True(t, err == nil || err == io.EOF)
+		assert.Equal(t, input[off:off+int64(n)], string(p[:n]), "Range is different")
+	}
+}
+
+func TestReadAtWhileFetchingLeaf(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	input := strings.Repeat("hello s3git: concurrent reads, ", 200)
+	rootKeyStr := writeTo(t, strings.NewReader(input))
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	config.Config.Remotes = []config.RemoteObject{{Name: "fake", Type: config.REMOTE_FAKE, FakeDirectory: fakeDir}}
+	defer func() { config.Config.Remotes = nil }()
+
+	cr, err := OpenReader(rootKeyStr)
+	assert.Nil(t, err)
+	defer cr.Close()
+
+	// Leaf at the remote is a pipe, so that fetching it blocks until it is written to
+	leaf := cr.leaves[2].String()
+	os.Remove(getBlobPathWithinArea(leaf, stageDir))
+	assert.Nil(t, syscall.Mkfifo(fakeDir + "/" + leaf, 0600))
+
+	done := make(chan string)
+	go func() {
+		p := make([]byte, 100)
+		cr.ReadAt(p, 2*1024)
+		done <- string(p)
+	}()
+
+	// Wait for the fetch to open the pipe, local leaves can be read in the meantime
+	var pipe *os.File
+	for pipe == nil {
+		pipe, _ = os.OpenFile(fakeDir + "/" + leaf, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	}
+	p := make([]byte, 100)
+	_, err = cr.ReadAt(p, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, input[10:110], string(p))
+
+	pipe.Write([]byte(input[2*1024:3*1024]))
+	pipe.Close()
+	assert.Equal(t, input[2*1024:2*1024+100], <-done)
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cas
+
+import (
+	"github.com/s3git/s3git-go/internal/config"
+)
+
+//
+// Content Defined Chunking
+// ========================
+//
+// When enabled (s3gitRollingHashBits is set) blobs are cut into leaves at positions that
+// depend on the content itself (as opposed to every LeafSize bytes). This way inserting or
+// deleting bytes only affects the leaves around the change and deduplication keeps working
+// for the remainder of the blob.
+//
+// A buzhash is computed over a sliding window and a leaf is cut whenever the lower bits of
+// the hash are all set (once the leaf is at least s3gitRollingHashMin bytes). Leaves are never
+// larger than LeafSize, so at that point a cut is forced.
+//
+// In order for identical leaves to deduplicate irrespective of their position within the
+// blob, leaves are hashed with a node offset of zero (instead of the index of the leaf).
+//
+// Note that the table below is part of the storage format: changing it changes the hashes.
+//
+
+const rollingHashWindow = 64
+
+var rollingHashTable [256]uint32
+
+func init() {
+	// Fill table deterministically using splitmix64
+	seed := uint64(0x5e6769742d434443) // "s3git-CDC"
+	for i := range rollingHashTable {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		rollingHashTable[i] = uint32(z ^ (z >> 31))
+	}
+}
+
+type rollingHash struct {
+	hash uint32
+	mask uint32
+	min  uint32
+}
+
+// Create a rolling hash for a given object type, returns nil when leaves are of fixed size
+func makeRollingHash(objType string) *rollingHash {
+
+	// Only apply to blobs, other objects (like prefix objects) are required to be a single leaf
+	if objType != BLOB || config.Config.RollingHashBits == 0 {
+		return nil
+	}
+
+	return &rollingHash{mask: (1 << uint(config.Config.RollingHashBits)) - 1, min: uint32(config.Config.RollingHashMin)}
+}
+
+// Start computing over a new leaf
+func (rh *rollingHash) reset() {
+	rh.hash = 0
+}
+
+// Roll in the next byte of a leaf (and roll out the byte leaving the window), where size is the
+// number of bytes in the leaf including the new byte. Returns true when the leaf is to be cut.
+func (rh *rollingHash) roll(in, out byte, size uint32) bool {
+
+	rh.hash = rh.hash<<1 | rh.hash>>31
+	if size > rollingHashWindow {
+		rh.hash ^= rollingHashTable[out] // rotation over the window size is a no-op for a 64 byte window
+	}
+	rh.hash ^= rollingHashTable[in]
+
+	return size >= rh.min && rh.hash&rh.mask == rh.mask
+}
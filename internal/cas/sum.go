@@ -17,9 +17,10 @@
 package cas
 
 import (
-	"bytes"
+	"bufio"
 	"io"
 	"os"
+	"runtime"
 	"sync"
 
 	"encoding/hex"
@@ -28,20 +29,24 @@ import (
 )
 
 // Worker routine for computing hash for a chunk
-func calcChunkWorkers(chunks <-chan chunkInput, results chan<- chunkOutput) {
+func calcChunkWorkers(chunks <-chan chunkInput, results chan<- chunkOutput, buffers *leafBuffers) {
 
 	for c := range chunks {
 
-		blake := blake2.New(&blake2.Config{Size: KeySize, Tree: &blake2.Tree{Fanout: 0, MaxDepth: 2, LeafSize: config.Config.LeafSize, NodeOffset: uint64(c.part), NodeDepth: 0, InnerHashSize: KeySize, IsLastNode: c.lastChunk}})
-
-		_, err := io.Copy(blake, bytes.NewBuffer(c.partBuffer))
-		if err != nil {
-			// TODO: fmt.Println("Failing to compute hash: ", err)
-			results <- chunkOutput{digest: []byte(""), part: c.part}
-		} else {
-			digest := blake.Sum(nil)
-			results <- chunkOutput{digest: digest, part: c.part}
+		nodeOffset := uint64(c.part)
+		if c.rolling {
+			nodeOffset = 0 // See rolling.go
 		}
+
+		blake := blake2.New(&blake2.Config{Size: KeySize, Tree: &blake2.Tree{Fanout: 0, MaxDepth: 2, LeafSize: config.Config.LeafSize, NodeOffset: nodeOffset, NodeDepth: 0, InnerHashSize: KeySize, IsLastNode: c.lastChunk}})
+
+		blake.Write(c.partBuffer)
+		digest := blake.Sum(nil)
+
+		// Buffer can be reused for next chunk
+		buffers.put(c.partBuffer)
+
+		results <- chunkOutput{digest: digest, part: c.part}
 	}
 }
 
@@ -51,6 +56,7 @@ type chunkInput struct {
 	lastChunk  bool
 	leafSize   uint32
 	level      int
+	rolling    bool
 }
 
 type chunkOutput struct {
@@ -61,25 +67,42 @@ type chunkOutput struct {
 func calcStream(r io.Reader, fileSize int64) (digest []byte, err error) {
 
 	var wg sync.WaitGroup
-	chunks := make(chan chunkInput)
-	results := make(chan chunkOutput)
+	cpus := runtime.NumCPU()
+	chunks := make(chan chunkInput, cpus)
+	results := make(chan chunkOutput, cpus)
+
+	// Buffers for chunks being read, queued, and hashed
+	buffers := makeLeafBuffers(2*cpus + 1)
+	defer buffers.release()
 
 	// Start one go routine per CPU
-	// TODO: Get nr of cpus
-	for i := 0; i < 1; /**cpu*/ i++ {
+	for i := 0; i < cpus; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			calcChunkWorkers(chunks, results)
+			calcChunkWorkers(chunks, results, buffers)
 		}()
 	}
 
+	rolling := makeRollingHash(BLOB)
+
+	var errRead error
+
 	// Push chunks onto input channel
 	go func() {
+		// Close input channel
+		defer close(chunks)
+
+		if rolling != nil {
+			errRead = pushRollingChunks(bufio.NewReader(r), rolling, buffers, chunks)
+			return
+		}
+
 		for part, totalSize := 0, int64(0); ; part++ {
-			partBuffer := make([]byte, config.Config.LeafSize)
-			n, err := r.Read(partBuffer)
-			if err != nil {
+			partBuffer := buffers.get()
+			n, err := io.ReadFull(r, partBuffer)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				errRead = err
 				return
 			}
 			partBuffer = partBuffer[:n]
@@ -90,12 +113,9 @@ func calcStream(r io.Reader, fileSize int64) (digest []byte, err error) {
 			chunks <- chunkInput{part: part, partBuffer: partBuffer, lastChunk: lastChunk, leafSize: config.Config.LeafSize, level: 0}
 
 			if lastChunk {
-				break
+				return
 			}
 		}
-
-		// Close input channel
-		close(chunks)
 	}()
 
 	// Wait for workers to complete
@@ -104,14 +124,17 @@ func calcStream(r io.Reader, fileSize int64) (digest []byte, err error) {
 		close(results) // Close output channel
 	}()
 
-	// Create hash based on chunk number with digest of chunk
+	// Collect digests by chunk number as they may arrive out of order
 	// (number of chunks upfront is unknown for stdin stream)
 	digestHash := make(map[int][]byte)
 	for r := range results {
 		digestHash[r.part] = r.digest
 	}
+	if errRead != nil {
+		return nil, errRead
+	}
 
-	// Concatenate digests of chunks
+	// Concatenate digests of chunks in order
 	b := make([]byte, len(digestHash)*KeySize)
 	for index, val := range digestHash {
 		offset := KeySize * index
@@ -121,13 +144,56 @@ func calcStream(r io.Reader, fileSize int64) (digest []byte, err error) {
 	rootBlake := blake2.New(&blake2.Config{Size: KeySize, Tree: &blake2.Tree{Fanout: 0, MaxDepth: 2, LeafSize: config.Config.LeafSize, NodeOffset: 0, NodeDepth: 1, InnerHashSize: KeySize, IsLastNode: true}})
 
 	// Compute top level digest
-	rootBlake.Reset()
-	_, err = io.Copy(rootBlake, bytes.NewBuffer(b))
+	rootBlake.Write(b)
 	digest = rootBlake.Sum(nil)
 
 	return digest, nil
 }
 
+// Push chunks onto input channel using content defined chunking (see rolling.go)
+func pushRollingChunks(br *bufio.Reader, rolling *rollingHash, buffers *leafBuffers, chunks chan<- chunkInput) error {
+
+	part := 0
+	partBuffer := buffers.get()[:0]
+
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		partBuffer = append(partBuffer, b)
+
+		size := uint32(len(partBuffer))
+		var out byte
+		if size > rollingHashWindow {
+			out = partBuffer[size-1-rollingHashWindow]
+		}
+		if rolling.roll(b, out, size) || size == config.Config.LeafSize {
+
+			// Check whether we are at the end of the stream
+			_, err := br.Peek(1)
+			lastChunk := err != nil
+
+			chunks <- chunkInput{part: part, partBuffer: partBuffer, lastChunk: lastChunk, leafSize: config.Config.LeafSize, level: 0, rolling: true}
+
+			if lastChunk {
+				return nil
+			}
+
+			part++
+			partBuffer = buffers.get()[:0]
+			rolling.reset()
+		}
+	}
+
+	// Remaining bytes form the last chunk
+	chunks <- chunkInput{part: part, partBuffer: partBuffer, lastChunk: true, leafSize: config.Config.LeafSize, level: 0, rolling: true}
+
+	return nil
+}
+
 func Sum(filename string) (string, error) {
 
 	f, err := os.Open(filename)
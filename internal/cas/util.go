@@ -17,6 +17,11 @@
 package cas
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
 	"github.com/s3git/s3git-go/internal/backend"
 	"github.com/s3git/s3git-go/internal/config"
 	"github.com/s3git/s3git-go/internal/kv"
@@ -24,10 +29,16 @@ import (
 	"path"
 )
 
+// Serializes checking the size of the repository, as leaves are written concurrently
+var repoSizeMutex sync.Mutex
+
 // Upon writing, make sure the size of the repository does not exceed the max local size,
 // prune stale chunks otherwise
 func checkRepoSize() error {
 
+	repoSizeMutex.Lock()
+	defer repoSizeMutex.Unlock()
+
 	// TODO: [perf] Maybe cache sizes of stage & cache area as they may be expensive for large repositories
 
 	stageSize, err := kv.GetLevel0StageSize()
@@ -98,18 +109,25 @@ func getBlobPath(hash string) string {
 	return nameInCache
 }
 
-// Push a low level leaf node to a remote back end
+// Push a low level leaf node to a remote back end (compressed when configured)
 func PushLeafBlob(hash string, client backend.Backend) error {
 
 	path := getBlobPath(hash)
 
-	file, err := os.Open(path)
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	err = client.UploadWithReader(hash, file)
+	// Compress leaf for transit when stored uncompressed
+	if !hasLeafHeader(data) && configCodec() != codecNone {
+		data, err = encodeLeaf(data, configCodec())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.UploadWithReader(hash, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -117,9 +135,10 @@ func PushLeafBlob(hash string, client backend.Backend) error {
 	return nil
 }
 
-// Fetch a low level leaf node from a remote back end
-func FetchLeafBlob(hash string, client backend.Backend) error {
+// Fetch a low level leaf node of a blob from a remote back end (the leaf is verified against its hash)
+func FetchLeafBlob(leaves []Key, leafNr int, client backend.Backend) error {
 
+	hash := leaves[leafNr].String()
 	filename := getBlobPathWithinArea(hash, cacheDir)
 
 	if _, err := os.Stat(filename); err == nil {
@@ -127,32 +146,70 @@ func FetchLeafBlob(hash string, client backend.Backend) error {
 		return nil
 	}
 
-	// Otherwise create file
-	file, err := createLeafNodeFile(hash, cacheDir)
+	// Download
+	buf := &leafWriterAt{}
+	err := client.DownloadWithWriter(hash, buf)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	// And download
-	err = client.DownloadWithWriter(hash, file)
+	// Decompress leaf as the codec of the remote may differ
+	chunk, err := decodeLeaf(buf.data)
 	if err != nil {
 		return err
 	}
 
-	// Get file size
-	fi, err := os.Stat(filename)
-	if err != nil {
-		return err
+	if !verifyLeaf(leaves, leafNr, chunk) {
+		return errors.New(fmt.Sprintf("Leaf %s does not match its hash", hash))
 	}
 
-	// Add size of leaf to KV store
-	err = addLeafBlobFileToKV(hash, cacheDir, uint32(fi.Size()))
-	if err != nil {
-		return err
+	// Store (compressed when configured) and add size of leaf to KV store
+	return storeLeaf(leaves[leafNr], chunk, cacheDir)
+}
+
+// Check that a leaf matches its hash. The leaf is hashed as it may have been written: as a leaf
+// of a fixed size (where the leaf size may differ from the configured leaf size) or as a content
+// defined chunk (which always has a node offset of zero)
+func verifyLeaf(leaves []Key, leafNr int, chunk []byte) bool {
+
+	isLastNode := leafNr == len(leaves)-1
+	if computeLeafKey(chunk, uint64(leafNr), isLastNode) == leaves[leafNr] || computeLeafKey(chunk, 0, isLastNode) == leaves[leafNr] {
+		return true
 	}
 
-	return nil
+	// Leaf size is given by the size of any leaf except for the last
+	leafSize := uint32(len(chunk))
+	if isLastNode && leafNr > 0 {
+		size, found, err := kv.GetLevel0Size(leaves[0].String())
+		if err != nil || !found {
+			return false
+		}
+		leafSize = size
+	}
+	return computeLeafKeyWithSize(chunk, leafSize, uint64(leafNr), isLastNode) == leaves[leafNr]
+}
+
+// Memory buffer to download a leaf to
+type leafWriterAt struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (w *leafWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if end := int(off) + len(p); end > cap(w.data) {
+		data := make([]byte, end, 2*end)
+		copy(data, w.data)
+		w.data = data
+	} else if end > len(w.data) {
+		w.data = w.data[:end]
+	}
+	copy(w.data[off:], p)
+
+	return len(p), nil
 }
 
 // Get the filepath for a given hash in either the .stage or .cache area
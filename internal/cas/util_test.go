@@ -17,7 +17,20 @@
 package cas
 
 import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"github.com/s3git/s3git-go/internal/backend"
+	"github.com/s3git/s3git-go/internal/backend/fake"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/s3git/s3git-go/internal/kv"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestRepoSize(t *testing.T) {
@@ -26,7 +39,350 @@ func TestRepoSize(t *testing.T) {
 
 func TestRepoSizeFetchLeafAgain(t *testing.T) {
 
-	// TODO: [test] Delete a leaf node as part of the pruning process and make sure it is fetched again from upstream when needed again
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	var input string
+	for i := 0; len(input) < 5000; i++ {
+		input += fmt.Sprintf("hello s3git: %d, ", i)
+	}
+	hash := writeTo(t, strings.NewReader(input))
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	config.Config.Remotes = []config.RemoteObject{{Name: "fake", Type: config.REMOTE_FAKE, FakeDirectory: fakeDir}}
+	defer func() { config.Config.Remotes = nil }()
+
+	leaves, err := openRoot(hash)
+	assert.Nil(t, err)
+
+	// Hydrated remote: missing leaf is fetched with a ranged download
+	ioutil.WriteFile(fakeDir + "/" + hash, []byte(input), os.ModePerm)
+	os.Remove(getBlobPathWithinArea(leaves[2].String(), stageDir))
+	assert.Equal(t, input[2*1024+10:2*1024+110], readAt(t, hash, 2*1024+10, 100), "Range is different")
+	assert.True(t, leafInCache(leaves[2]), "Leaf is not fetched to cache")
+	assert.False(t, leafInCache(leaves[1]), "Unexpected leaf fetched to cache")
+
+	// Deduped remote: missing leaf is fetched individually
+	os.Remove(fakeDir + "/" + hash)
+	ioutil.WriteFile(fakeDir + "/" + leaves[3].String(), []byte(input[3*1024:4*1024]), os.ModePerm)
+	os.Remove(getBlobPathWithinArea(leaves[3].String(), stageDir))
+	assert.Equal(t, input[3*1024:3*1024+100], readAt(t, hash, 3*1024, 100), "Range is different")
+	assert.True(t, leafInCache(leaves[3]), "Leaf is not fetched to cache")
+}
+
+func TestFetchContentDefinedLeafAtOffset(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 64 * 1024
+	config.Config.RollingHashBits = 12
+	config.Config.RollingHashMin = 1024
+
+	data := make([]byte, 512*1024)
+	rand.New(rand.NewSource(42)).Read(data)
+	input := string(data)
+	hash := writeTo(t, strings.NewReader(input))
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	config.Config.Remotes = []config.RemoteObject{{Name: "fake", Type: config.REMOTE_FAKE, FakeDirectory: fakeDir}}
+	defer func() { config.Config.Remotes = nil }()
+	ioutil.WriteFile(fakeDir + "/" + hash, data, os.ModePerm)
+
+	// Leaves (and their sizes) are no longer available locally
+	leaves, err := openRoot(hash)
+	assert.Nil(t, err)
+	assert.True(t, len(leaves) > 2, "Expected multiple content defined leaves")
+	for _, l := range leaves {
+		os.Remove(getBlobPathWithinArea(l.String(), stageDir))
+		kv.MoveLevel0FromStageToCache(l.String())
+		kv.RemoveLevel0FromCache(l.String())
+	}
+
+	// Boundaries follow from the sizes in the index, so only the leaf at the offset is fetched
+	off := int64(len(input)) - 100
+	assert.Equal(t, input[off:], readAt(t, hash, off, 100), "Range is different")
+	assert.True(t, leafInCache(leaves[len(leaves)-1]), "Leaf is not fetched to cache")
+	for _, l := range leaves[:len(leaves)-1] {
+		assert.False(t, leafInCache(l), "Unexpected leaf fetched to cache")
+	}
+}
+
+func TestPushAndFetchCompressedLeaf(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+	config.Config.Compression = config.COMPRESSION_SNAPPY
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	client := fake.MakeClient(config.RemoteObject{Type: config.REMOTE_FAKE, FakeDirectory: fakeDir})
+
+	input := strings.Repeat("hello s3git ", 1000)
+	hash := writeTo(t, strings.NewReader(input))
+	leaves, _ := openRoot(hash)
+
+	for _, l := range leaves {
+		assert.Nil(t, PushLeafBlob(l.String(), client))
+	}
+
+	// Fetch leaf again into uncompressed repository
+	config.Config.Compression = config.COMPRESSION_NONE
+	os.Remove(getBlobPathWithinArea(leaves[1].String(), stageDir))
+	assert.Nil(t, FetchLeafBlob(leaves, 1, client))
+
+	data, _ := ioutil.ReadFile(getBlobPathWithinArea(leaves[1].String(), cacheDir))
+	assert.Equal(t, input[1024:2048], string(data), "Leaf is not stored uncompressed")
+	assert.Equal(t, input, readBack(t, hash), "Input and output are different")
+}
+
+func TestPullBlobInRanges(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	client := fake.MakeClient(config.RemoteObject{Type: config.REMOTE_FAKE, FakeDirectory: fakeDir})
+
+	// Include size that is an exact multiple of the leaf size and a leaf hash list exceeding a single leaf
+	for _, size := range []int{10, 1024, 1025, 4096, 5000, 20000} {
+		var input string
+		for i := 0; len(input) < size; i++ {
+			input += fmt.Sprintf("%d: hello s3git, ", i)
+		}
+		input = input[:size]
+		hash := writeTo(t, strings.NewReader(input))
+
+		// Hydrated remote
+		ioutil.WriteFile(fakeDir + "/" + hash, []byte(input), os.ModePerm)
+		leafHashes, err := PullBlobDownToLocalDisk(hash, kv.BLOB, client)
+		assert.Nil(t, err)
+		expected, _, _ := kv.GetLevel1(keyOf(t, hash))
+		assert.Equal(t, expected, leafHashes, "Leaf hashes are different for size %d", size)
+
+		leaves, _ := openRoot(hash)
+		for _, l := range leaves {
+			assert.True(t, leafInCache(l), "Leaf is not pulled to cache for size %d", size)
+		}
+
+		// Deduped remote
+		ioutil.WriteFile(fakeDir + "/" + hash, expected, os.ModePerm)
+		leafHashes, err = PullBlobDownToLocalDisk(hash, kv.BLOB, client)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, leafHashes, "Leaf hashes are different for deduped size %d", size)
+	}
+}
+
+func TestFetchLeafBlobVerifiesHash(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	client := fake.MakeClient(config.RemoteObject{Type: config.REMOTE_FAKE, FakeDirectory: fakeDir})
+
+	input := strings.Repeat("hello s3git: verify ", 300)
+	hash := writeTo(t, strings.NewReader(input))
+	leaves, _ := openRoot(hash)
+
+	// Contents of another leaf stored under the name of the leaf
+	ioutil.WriteFile(fakeDir + "/" + leaves[1].String(), []byte(input[2*1024:3*1024]), os.ModePerm)
+	os.Remove(getBlobPathWithinArea(leaves[1].String(), stageDir))
+	assert.NotNil(t, FetchLeafBlob(leaves, 1, client), "Expected error for leaf that does not match its hash")
+	assert.False(t, leafInCache(leaves[1]), "Mismatching leaf is stored")
+
+	ioutil.WriteFile(fakeDir + "/" + leaves[1].String(), []byte(input[1024:2*1024]), os.ModePerm)
+	assert.Nil(t, FetchLeafBlob(leaves, 1, client))
+	assert.True(t, leafInCache(leaves[1]), "Leaf is not fetched to cache")
+}
+
+// Back end that counts the number of ranged downloads
+type countingRangesBackend struct {
+	backend.Backend
+	ranges int
+}
+
+func (c *countingRangesBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+	c.ranges++
+	return c.Backend.DownloadRange(hash, offset, length, w)
+}
+
+func TestPullDedupedBlobNotInRanges(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	client := &countingRangesBackend{Backend: fake.MakeClient(config.RemoteObject{Type: config.REMOTE_FAKE, FakeDirectory: fakeDir})}
+
+	// Leaf hash list exceeds a single leaf
+	input := strings.Repeat("hello s3git: deduped, ", 2000)
+	hash := writeTo(t, strings.NewReader(input))
+	expected, _, _ := kv.GetLevel1(keyOf(t, hash))
+	assert.True(t, len(expected) > 1024)
+
+	leaves, _ := openRoot(hash)
+	for _, l := range leaves {
+		assert.Nil(t, PushLeafBlob(l.String(), client))
+	}
+	ioutil.WriteFile(fakeDir + "/" + hash, expected, os.ModePerm)
+
+	leafHashes, err := PullBlobDownToLocalDisk(hash, kv.BLOB, client)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, leafHashes, "Leaf hashes are different")
+	assert.Equal(t, 1, client.ranges, "Expected only the first range to be downloaded")
+}
+
+func TestPullHydratedInRangesRootMismatch(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	client := fake.MakeClient(config.RemoteObject{Type: config.REMOTE_FAKE, FakeDirectory: fakeDir})
+
+	hash := writeTo(t, strings.NewReader(strings.Repeat("hello s3git: expected, ", 300)))
+
+	// Object does not hold the contents of the blob
+	other := strings.Repeat("hello s3git: other, ", 300)
+	ioutil.WriteFile(fakeDir + "/" + hash, []byte(other), os.ModePerm)
+
+	first, err := downloadLeafRange(hash, 0, client)
+	assert.Nil(t, err)
+	leafHashes, err := pullHydratedInRanges(hash, first, nil, client, rangedDownloaders)
+	assert.Nil(t, err)
+	assert.Nil(t, leafHashes, "Expected mismatch")
+
+	for leafNr := 0; leafNr*1024 < len(other); leafNr++ {
+		end := (leafNr+1)*1024
+		if end > len(other) {
+			end = len(other)
+		}
+		leafKey := computeLeafKey([]byte(other[leafNr*1024:end]), uint64(leafNr), end == len(other))
+		assert.False(t, leafInCache(leafKey), "Leaf of mismatching object is stored")
+	}
+}
+
+// Back end that holds ranged downloads beyond the first leaf until released
+type blockingRangesBackend struct {
+	backend.Backend
+	blocked chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingRangesBackend) DownloadRange(hash string, offset, length int64, w io.Writer) error {
+	if offset > 0 {
+		b.once.Do(func() { close(b.blocked) })
+		<-b.release
+	}
+	return b.Backend.DownloadRange(hash, offset, length, w)
+}
+
+func TestReadLeafBeforeAllRangesArePulled(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+	client := &blockingRangesBackend{
+		Backend: fake.MakeClient(config.RemoteObject{Type: config.REMOTE_FAKE, FakeDirectory: fakeDir}),
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	input := strings.Repeat("hello s3git: before all ranges, ", 200)
+	hash := writeTo(t, strings.NewReader(input))
+	ioutil.WriteFile(fakeDir + "/" + hash, []byte(input), os.ModePerm)
+
+	leaves, _ := openRoot(hash)
+	for _, l := range leaves {
+		os.Remove(getBlobPathWithinArea(l.String(), stageDir))
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := pullBlobDownToLocalDisk(hash, kv.BLOB, client, 2)
+		done <- err
+	}()
+
+	// First leaf can be read while the other ranges are still being downloaded
+	<-client.blocked
+	assert.Equal(t, input[:1024], readAt(t, hash, 0, 1024))
+	assert.False(t, leafInCache(leaves[len(leaves)-1]), "Expected last leaf to be pending")
+
+	close(client.release)
+	assert.Nil(t, <-done)
+	for _, l := range leaves {
+		assert.True(t, leafInCache(l), "Leaf is not pulled to cache")
+	}
+}
+
+func TestPullClientsFollowConcurrencyOfRemote(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(fakeDir)
+
+	config.Config.Remotes = []config.RemoteObject{
+		{Name: "limited", Type: config.REMOTE_FAKE, FakeDirectory: fakeDir, Limits: &config.LimitsObject{Concurrency: 3}},
+		{Name: "default", Type: config.REMOTE_FAKE, FakeDirectory: fakeDir},
+	}
+	defer func() { config.Config.Remotes = nil }()
+
+	clients, err := getPullClients()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(clients))
+	assert.Equal(t, 3, clients[0].concurrency)
+	assert.Equal(t, rangedDownloaders, clients[1].concurrency)
+}
+
+func keyOf(t *testing.T, hash string) []byte {
+	b, err := hex.DecodeString(hash)
+	assert.Nil(t, err)
+	return b
+}
+
+func readAt(t *testing.T, hash string, off int64, length int) string {
+
+	cr, err := OpenReader(hash)
+	assert.Nil(t, err)
+	defer cr.Close()
+
+	p := make([]byte, length)
+	_, err = cr.ReadAt(p, off)
+	assert.Nil(t, err)
+
+	return string(p)
+}
+
+func leafInCache(leaf Key) bool {
+
+	_, err := os.Stat(getBlobPathWithinArea(leaf.String(), cacheDir))
+	return err == nil
 }
 
 func TestLeafNodeAlreadyInCache(t *testing.T) {
@@ -27,11 +27,18 @@ import (
 	"encoding/hex"
 	"github.com/bmatsuo/lmdb-go/lmdb"
 	"fmt"
+	"runtime"
+	"sync"
+	"hash"
+	"time"
+	"github.com/s3git/s3git-go/internal/metrics"
 )
 
 func MakeWriter(objType string) *Writer {
 	cw := Writer{areaDir: stageDir, objType: objType}
-	cw.chunkBuf = make([]byte, config.Config.LeafSize)
+	cw.buffers = makeLeafBuffers(runtime.NumCPU() + 1)
+	cw.chunkBuf = cw.buffers.get()
+	cw.rolling = makeRollingHash(objType)
 	return &cw
 }
 
@@ -45,11 +52,18 @@ func MakeWriterInCheatMode(objType string) *Writer {
 type Writer struct {
 	cheatMode   bool
 	leaves      []Key
+	sizes       []uint32 // Size of each leaf
 	chunkBuf    []byte
 	chunkOffset uint32
 	objType     string
 	areaDir		string
 	flushed		bool
+	rolling     *rollingHash // Set when using content defined chunking
+	cut         bool         // Leaf is to be cut before next byte is written
+	buffers     *leafBuffers // Buffers for leaves being filled or hashed
+	wg          sync.WaitGroup
+	mutex       sync.Mutex // Protects leaves and err while leaves are being hashed
+	err         error
 }
 
 func (cw *Writer) setAreaDir(dir string) {
@@ -58,6 +72,18 @@ func (cw *Writer) setAreaDir(dir string) {
 
 func (cw *Writer) Write(p []byte) (nn int, err error) {
 
+	// Report the first error of writing leaves in the background
+	cw.mutex.Lock()
+	err = cw.err
+	cw.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	if cw.rolling != nil {
+		return cw.writeRolling(p)
+	}
+
 	for bytesToWrite := uint32(len(p)); bytesToWrite > 0; {
 
 		if cw.chunkOffset == config.Config.LeafSize {
@@ -80,34 +106,131 @@ func (cw *Writer) Write(p []byte) (nn int, err error) {
 	return len(p), nil
 }
 
-// Write leaf node to disk
+// Write using content defined chunking
+func (cw *Writer) writeRolling(p []byte) (nn int, err error) {
+
+	for _, b := range p {
+
+		if cw.cut {
+			// Write out leaf (without last chunk marker)
+			cw.flush(false)
+		}
+
+		cw.chunkBuf[cw.chunkOffset] = b
+		cw.chunkOffset++
+
+		var out byte
+		if cw.chunkOffset > rollingHashWindow {
+			out = cw.chunkBuf[cw.chunkOffset-1-rollingHashWindow]
+		}
+		cw.cut = cw.rolling.roll(b, out, cw.chunkOffset) || cw.chunkOffset == config.Config.LeafSize
+	}
+
+	return len(p), nil
+}
+
+// Hand off leaf node to be hashed and written to disk in the background (bounded by
+// the number of buffers, so this blocks when all CPUs are busy hashing)
 func (cw *Writer) flush(isLastNode bool) {
 
-	blake2 := blake2.New(&blake2.Config{Size: 64, Tree: &blake2.Tree{Fanout: 0, MaxDepth: 2, LeafSize: config.Config.LeafSize, NodeOffset: uint64(len(cw.leaves)), NodeDepth: 0, InnerHashSize: 64, IsLastNode: isLastNode}})
-	blake2.Write(cw.chunkBuf[:cw.chunkOffset])
+	cw.mutex.Lock()
+	index, nodeOffset := len(cw.leaves), cw.nodeOffset()
+	cw.leaves = append(cw.leaves, Key{})
+	cw.sizes = append(cw.sizes, cw.chunkOffset)
+	cw.mutex.Unlock()
+
+	cw.wg.Add(1)
+	go func(chunk []byte) {
+		defer cw.wg.Done()
+
+		start := time.Now()
+		leafKey, err := writeLeaf(chunk, nodeOffset, isLastNode, cw.areaDir)
+		metrics.CasFlushDuration.Since("", start)
+		metrics.CasFlushBytes.Add("", float64(len(chunk)))
+
+		cw.mutex.Lock()
+		cw.leaves[index] = leafKey
+		if err != nil && cw.err == nil {
+			cw.err = err
+		}
+		cw.mutex.Unlock()
+
+		cw.buffers.put(chunk)
+	}(cw.chunkBuf[:cw.chunkOffset])
+
+	// Start over in (next) buffer
+	if isLastNode {
+		cw.chunkBuf = nil
+	} else {
+		cw.chunkBuf = cw.buffers.get()
+	}
+	cw.chunkOffset = 0
+	cw.cut = false
+	if cw.rolling != nil {
+		cw.rolling.reset()
+	}
+}
+
+// Compute hash for leaf node and write to disk
+func writeLeaf(chunk []byte, nodeOffset uint64, isLastNode bool, areaDir string) (Key, error) {
+
+	leafKey := computeLeafKey(chunk, nodeOffset, isLastNode)
+
+	return leafKey, storeLeaf(leafKey, chunk, areaDir)
+}
+
+// Compute hash for leaf node
+func computeLeafKey(chunk []byte, nodeOffset uint64, isLastNode bool) Key {
+
+	return computeLeafKeyWithSize(chunk, config.Config.LeafSize, nodeOffset, isLastNode)
+}
 
-	leafKey := NewKey(blake2.Sum(nil))
-	cw.leaves = append(cw.leaves, leafKey)
+// Compute the key of a leaf for a blob that was written with the given leaf size
+func computeLeafKeyWithSize(chunk []byte, leafSize uint32, nodeOffset uint64, isLastNode bool) Key {
+
+	blake2 := blake2.New(&blake2.Config{Size: 64, Tree: &blake2.Tree{Fanout: 0, MaxDepth: 2, LeafSize: leafSize, NodeOffset: nodeOffset, NodeDepth: 0, InnerHashSize: 64, IsLastNode: isLastNode}})
+	blake2.Write(chunk)
+
+	return NewKey(blake2.Sum(nil))
+}
+
+// Write leaf node to disk (compressed when configured)
+func storeLeaf(leafKey Key, chunk []byte, areaDir string) error {
+
+	data, err := encodeLeaf(chunk, configCodec())
+	if err != nil {
+		return err
+	}
 
 	// Create file
-	chunkWriter, err := createLeafNodeFile(leafKey.String(), cw.areaDir)
+	chunkWriter, err := createLeafNodeFile(leafKey.String(), areaDir)
 	if err != nil {
-		return
+		return err
 	}
+	defer chunkWriter.Close()
 
 	// Write leaf blob contents to file
-	chunkWriter.Write(cw.chunkBuf[:cw.chunkOffset])
-	defer chunkWriter.Close()
+	_, err = chunkWriter.Write(data)
+	if err != nil {
+		return err
+	}
 	chunkWriter.Sync()
 
 	// Add size of leaf to KV store
-	err = addLeafBlobFileToKV(leafKey.String(), cw.areaDir, cw.chunkOffset)
+	err = addLeafBlobFileToKV(leafKey.String(), areaDir, uint32(len(chunk)))
 	if err != nil {
-		return
+		return err
 	}
 
-	// Start over in buffer
-	cw.chunkOffset = 0
+	return nil
+}
+
+// Get the node offset for the next leaf (always zero for content defined chunking)
+func (cw *Writer) nodeOffset() uint64 {
+	if cw.rolling != nil {
+		return 0
+	}
+	return uint64(len(cw.leaves))
 }
 
 func (cw *Writer) Flush() (string, []byte, bool, error) {
@@ -115,8 +238,19 @@ func (cw *Writer) Flush() (string, []byte, bool, error) {
 	// Close last node
 	cw.flush(true)
 
+	// Wait for all leaves to be written
+	cw.wg.Wait()
+	cw.buffers.release()
+
+	// Check size of repo once all leaves are written, prune stale chunks if necessary
+	checkRepoSize()
+
 	cw.flushed = true
 
+	if cw.err != nil {
+		return "", nil, false, cw.err
+	}
+
 	rootStr, err := computeRootBlake2(cw.leaves)
 	if err != nil {
 		return "", nil, false, err
@@ -148,6 +282,14 @@ func (cw *Writer) Flush() (string, []byte, bool, error) {
 		}
 	}
 
+	// Store sizes of leaves so that leaf boundaries are known without loading the leaves
+	if cw.objType == kv.BLOB {
+		err := kv.AddLeafSizes(key, cw.sizes)
+		if err != nil {
+			return "", nil, false, err
+		}
+	}
+
 	return rootStr, leafHashes, newBlob, nil
 }
 
@@ -155,7 +297,7 @@ func (cw *Writer) Flush() (string, []byte, bool, error) {
 func computeRootBlake2(leaves []Key) (string, error) {
 
 	// Compute hash of level 1 root key
-	blake2 := blake2.New(&blake2.Config{Size: 64, Tree: &blake2.Tree{Fanout: 0, MaxDepth: 2, LeafSize: config.Config.LeafSize, NodeOffset: 0, NodeDepth: 1, InnerHashSize: 64, IsLastNode: true}})
+	blake2 := NewRootHasher()
 
 	// Iterate over hashes of all underlying nodes
 	for _, leave := range leaves {
@@ -165,6 +307,12 @@ func computeRootBlake2(leaves []Key) (string, error) {
 	return NewKey(blake2.Sum(nil)).String(), nil
 }
 
+// Create hasher to compute the level 1 root key over the concatenated hashes of the leaves
+func NewRootHasher() hash.Hash {
+
+	return blake2.New(&blake2.Config{Size: 64, Tree: &blake2.Tree{Fanout: 0, MaxDepth: 2, LeafSize: config.Config.LeafSize, NodeOffset: 0, NodeDepth: 1, InnerHashSize: 64, IsLastNode: true}})
+}
+
 func (cw *Writer) Close() error {
 	if !cw.flushed {
 		return errors.New("Stream closed without being flushed!")
@@ -175,8 +323,6 @@ func (cw *Writer) Close() error {
 // Create a file for a leaf node
 func createLeafNodeFile(hash, areaDir string) (*os.File, error) {
 
-	checkRepoSize()
-
 	hashDir := path.Join(config.Config.BasePath, config.S3GIT_DIR, areaDir, hash[0:2], hash[2:4]) + "/"
 	err := os.MkdirAll(hashDir, os.ModePerm)
 	if err != nil {
@@ -84,6 +84,186 @@ func TestWriteDifferentChunkSize(t *testing.T) {
 	assert.Equal(t, input, output, "Input and output are different")
 }
 
+func TestWriteRollingHash(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 64 * 1024
+	config.Config.RollingHashBits = 12
+	config.Config.RollingHashMin = 1024
+
+	input := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(42)).Read(input)
+
+	cw := MakeWriter(BLOB)
+	_, err := io.Copy(cw, bytes.NewReader(input))
+	assert.Nil(t, err)
+	rootKeyStr, leafHashes, _, err := cw.Flush()
+	assert.Nil(t, err)
+
+	leaves := len(leafHashes) / KeySize
+	assert.True(t, leaves > 1024*1024/64/1024, "Expected leaves smaller than leaf size")
+
+	output := readBack(t, rootKeyStr)
+	assert.Equal(t, string(input), output, "Input and output are different")
+
+	// Sum should give identical hash
+	name := path + "/input.bin"
+	ioutil.WriteFile(name, input, 0644)
+	digest, err := Sum(name)
+	assert.Nil(t, err)
+	assert.Equal(t, rootKeyStr, digest, "Sum differs from hash computed by writer")
+
+	// Insert a single byte halfway and verify that (nearly) all other leaves are unchanged
+	modified := append(append(append([]byte{}, input[:len(input)/2]...), 'x'), input[len(input)/2:]...)
+	cw = MakeWriter(BLOB)
+	io.Copy(cw, bytes.NewReader(modified))
+	_, leafHashesModified, _, err := cw.Flush()
+	assert.Nil(t, err)
+
+	shared := 0
+	for i := 0; i < len(leafHashes); i += KeySize {
+		for j := 0; j < len(leafHashesModified); j += KeySize {
+			if bytes.Equal(leafHashes[i:i+KeySize], leafHashesModified[j:j+KeySize]) {
+				shared++
+				break
+			}
+		}
+	}
+	assert.True(t, shared >= leaves-2, "Expected all but the modified leaves to be deduplicated")
+}
+
+func TestWriteLeavesInOrder(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	// Leaves are hashed in parallel, but need to be listed in the order of the blob
+	input := make([]byte, 100*1024+10)
+	rand.New(rand.NewSource(42)).Read(input)
+
+	cw := MakeWriter(BLOB)
+	for i := 0; i < len(input); i += 100 {
+		end := i + 100
+		if end > len(input) {
+			end = len(input)
+		}
+		_, err := cw.Write(input[i:end])
+		assert.Nil(t, err)
+	}
+	rootKeyStr, leafHashes, _, err := cw.Flush()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 101, len(leafHashes)/KeySize)
+	for leafNr := 0; leafNr*1024 < len(input); leafNr++ {
+		end := (leafNr+1)*1024
+		if end > len(input) {
+			end = len(input)
+		}
+		leafKey := computeLeafKey(input[leafNr*1024:end], uint64(leafNr), end == len(input))
+		assert.Equal(t, leafKey.object[:], leafHashes[leafNr*KeySize:(leafNr+1)*KeySize], "Leaf %d is out of order", leafNr)
+	}
+	assert.Equal(t, string(input), readBack(t, rootKeyStr), "Input and output are different")
+}
+
+func TestSumMatchesWriter(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 64 * 1024
+
+	for _, size := range []int{10, 64*1024, 64*1024+1, 1024*1024, 5*1024*1024+7} {
+		input := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(input)
+
+		name := path + "/input.bin"
+		ioutil.WriteFile(name, input, 0644)
+		digest, err := Sum(name)
+		assert.Nil(t, err)
+		assert.Equal(t, writeTo(t, bytes.NewReader(input)), digest, "Sum differs from hash computed by writer for size %d", size)
+	}
+}
+
+func TestWriteReportsLeafError(t *testing.T) {
+
+	path := setupRepo(t)
+	defer teardownRepo(path)
+
+	config.Config.LeafSize = 1024
+
+	// Leaves cannot be added to the KV store for an unknown area
+	cw := MakeWriter(BLOB)
+	cw.setAreaDir("unknown")
+
+	_, err := cw.Write(make([]byte, 1024+1))
+	assert.Nil(t, err)
+	cw.wg.Wait()
+
+	_, err = cw.Write([]byte("more"))
+	assert.NotNil(t, err, "Expected error of leaf written in the background")
+
+	_, _, _, err = cw.Flush()
+	assert.NotNil(t, err, "Expected error on flush")
+}
+
+func TestWriteCompressed(t *testing.T) {
+
+	for _, compression := range []string{config.COMPRESSION_SNAPPY, config.COMPRESSION_DEFLATE} {
+
+		path := setupRepo(t)
+
+		config.Config.LeafSize = 64 * 1024
+		input := strings.Repeat("id,name,value\n1,s3git,42\n", 10000)
+
+		uncompressed := writeTo(t, strings.NewReader(input))
+
+		config.Config.Compression = compression
+		compressed := writeTo(t, strings.NewReader(input + " "))
+		assert.NotEqual(t, uncompressed, compressed)
+
+		// Hash is identical to uncompressed write of same contents
+		hash := writeTo(t, strings.NewReader(input))
+		assert.Equal(t, uncompressed, hash, "Hash differs for %s", compression)
+
+		output := readBack(t, compressed)
+		assert.Equal(t, input + " ", output, "Input and output are different for %s", compression)
+
+		// Leaves are smaller on disk, but sizes in KV store are uncompressed
+		leaves, _ := openRoot(compressed)
+		fi, err := os.Stat(getBlobPath(leaves[0].String()))
+		assert.Nil(t, err)
+		assert.True(t, fi.Size() < int64(config.Config.LeafSize)/4, "Leaf not compressed for %s", compression)
+		size, found, _ := kv.GetLevel0Size(leaves[0].String())
+		assert.True(t, found)
+		assert.Equal(t, config.Config.LeafSize, size)
+
+		teardownRepo(path)
+	}
+}
+
+func TestEncodeLeafWithMagic(t *testing.T) {
+
+	// Uncompressed leaf that happens to start with the magic needs a header
+	chunk := append(append([]byte{}, leafHeaderMagic...), []byte("s3git s3git s3git")...)
+	data, err := encodeLeaf(chunk, codecNone)
+	assert.Nil(t, err)
+	assert.Equal(t, leafHeaderSize+len(chunk), len(data))
+
+	decoded, err := decodeLeaf(data)
+	assert.Nil(t, err)
+	assert.Equal(t, chunk, decoded)
+
+	// Incompressible leaf is stored as is
+	random := make([]byte, 1024)
+	rand.New(rand.NewSource(42)).Read(random)
+	data, err = encodeLeaf(random, codecSnappy)
+	assert.Nil(t, err)
+	assert.Equal(t, random, data)
+}
 
 func writeTo(t *testing.T, r io.Reader) string {
 
@@ -114,7 +294,7 @@ func readBack(t *testing.T, hash string) string {
 func setupRepo(t *testing.T) (string) {
 	path, _ := ioutil.TempDir("", "s3git-cas-")
 
-	config.SaveConfig(path)
+	config.SaveConfig(path, 0, 0, 0, 0, config.COMPRESSION_NONE)
 
 	success, err := config.LoadConfig(path)
 	assert.Nil(t, err)
@@ -18,6 +18,7 @@ package config
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -33,11 +34,29 @@ const REMOTE_S3 = "s3"
 const REMOTE_FAKE = "fake"
 const REMOTE_ACD = "acd"
 const REMOTE_DYNAMODB = "dynamodb"
+const REMOTE_FILE = "file"
+const REMOTE_HTTP = "http"
+const REMOTE_HTTPS = "https"
+const REMOTE_AZURE = "azure"
+const REMOTE_ARCHIVE = "archive"
+const REMOTE_SHARDED = "sharded"
+const REMOTE_ERASURE = "erasure"
+const REMOTE_TIERED = "tiered"
 
 const LeafSizeMinimum = 1024
 const LeafSizeDefault = 5 * 1024 * 1024
 const MaxRepoSizeMinimum = 1024 * 1024
 const MaxRepoSizeDefault = 25 * 1024 * 1024 * 1024
+const RollingHashBitsMinimum = 10
+const RollingHashBitsMaximum = 30
+const RollingHashMinMinimum = 64
+const TieredMaxMetadataSizeMinimum = 4 * 1024   // Prefix objects need to fit on the metadata tier
+const TieredMaxMetadataSizeDefault = 256 * 1024 // Well within the item limit of DynamoDB
+const COMPRESSION_NONE = ""
+const COMPRESSION_SNAPPY = "snappy"
+const COMPRESSION_DEFLATE = "deflate"
+const ENCRYPTION_KEY_ENV = "S3GIT_ENCRYPTION_KEY"
+const EncryptionKeySize = 32
 
 var Config ConfigObject
 
@@ -49,7 +68,13 @@ type ConfigObject struct {
 	MaxRepoSize     uint64         `json:"s3gitMaxRepoSize"`
 	RollingHashBits int            `json:"s3gitRollingHashBits"`
 	RollingHashMin  int            `json:"s3gitRollingHashMin"`
+	Compression     string         `json:"s3gitCompression"`
+	EncryptionKey   string         `json:"s3gitEncryptionKey"` // Hex encoded (can be overridden by S3GIT_ENCRYPTION_KEY)
 	Remotes         []RemoteObject `json:"s3gitRemotes"`
+	RemoteOrder     []string       `json:"s3gitRemoteOrder"` // Order in which remotes are tried for reads (by name)
+
+	// Read-only fallback remotes (tried in order) for objects that are missing on the remotes
+	Alternates []RemoteObject `json:"s3gitAlternates,omitempty"`
 }
 
 // Base object for Remotes
@@ -57,6 +82,17 @@ type RemoteObject struct {
 	Name    string `json:"Name"`
 	Type    string `json:"Type"`
 	Hydrate bool   `json:"Hydrate"`
+	Publish bool   `json:"Publish"` // Publish index on push for serving as static files
+
+	// Retrying of failed operations (defaults apply when not set)
+	Retry *RetryObject `json:"Retry,omitempty"`
+
+	// Limits for bandwidth, request rate and concurrency (unlimited when not set)
+	Limits *LimitsObject `json:"Limits,omitempty"`
+
+	// Provider for the credentials (keys below are only used for static credentials)
+	CredentialProvider string `json:"CredentialProvider,omitempty"`
+	CredentialSource   string `json:"CredentialSource,omitempty"` // Profile or command (depending on provider)
 
 	// Remote object for S3
 	S3Bucket    string `json:"S3Bucket"`
@@ -77,6 +113,91 @@ type RemoteObject struct {
 
 	// Remote object for fake backend
 	FakeDirectory string `json:"FakeDirectory"`
+
+	// Remote object for local file system (eg. NFS or USB disk)
+	FileDirectory string `json:"FileDirectory"`
+
+	// Remote object for (read-only) static web server
+	HttpUrl string `json:"HttpUrl"`
+
+	// Remote objects that a sharded remote spreads objects over (by hash prefix), that
+	// an erasure coded remote stores the data shards and then parity shards on, or the
+	// metadata tier and then bulk tier of a tiered remote
+	Shards                []RemoteObject `json:"Shards,omitempty"`
+	ErasureDataShards     int            `json:"ErasureDataShards,omitempty"`
+	TieredMaxMetadataSize int64          `json:"TieredMaxMetadataSize,omitempty"` // Maximum size of objects on the metadata tier
+
+	// Remote object for Azure Blob Storage (either account key or SAS token)
+	AzureContainer  string `json:"AzureContainer"`
+	AzureAccount    string `json:"AzureAccount"`
+	AzureAccountKey string `json:"AzureAccountKey"`
+	AzureSasToken   string `json:"AzureSasToken"`
+	AzureEndpoint   string `json:"AzureEndpoint"`
+
+	// Remote object for single file archive
+	ArchivePath     string `json:"ArchivePath"`
+	ArchiveReadOnly bool   `json:"ArchiveReadOnly"`
+	ArchiveMapSize  int64  `json:"ArchiveMapSize,omitempty"` // Initial size of the memory map (grows when full)
+}
+
+// Retry policy for a remote, zero values mean the default is used
+type RetryObject struct {
+	MaxAttempts      int     `json:"MaxAttempts"`      // 1 disables retrying
+	BackoffMillis    int     `json:"BackoffMillis"`    // Backoff before the first retry (doubles for every next retry)
+	MaxBackoffMillis int     `json:"MaxBackoffMillis"` // Maximum backoff between retries
+	Jitter           float64 `json:"Jitter"`           // Fraction of the backoff that is randomized (between 0 and 1)
+}
+
+type LimitsObject struct {
+	BytesPerSecond    int64   `json:"BytesPerSecond"`    // Bandwidth for uploads and downloads combined
+	RequestsPerSecond float64 `json:"RequestsPerSecond"` // Rate of requests to the remote
+	Concurrency       int     `json:"Concurrency"`       // Number of objects that are transferred in parallel
+}
+
+// Get the limits with the non-zero limits of the override taking precedence
+func (limits *LimitsObject) Override(override *LimitsObject) *LimitsObject {
+
+	if limits == nil && override == nil {
+		return nil
+	}
+
+	result := LimitsObject{}
+	if limits != nil {
+		result = *limits
+	}
+	if override != nil {
+		if override.BytesPerSecond > 0 {
+			result.BytesPerSecond = override.BytesPerSecond
+		}
+		if override.RequestsPerSecond > 0 {
+			result.RequestsPerSecond = override.RequestsPerSecond
+		}
+		if override.Concurrency > 0 {
+			result.Concurrency = override.Concurrency
+		}
+	}
+
+	return &result
+}
+
+// Get the number of parallel transfers (or the default when not limited)
+func (limits *LimitsObject) GetConcurrency(def int) int {
+
+	if limits == nil || limits.Concurrency <= 0 {
+		return def
+	}
+	return limits.Concurrency
+}
+
+// Get the maximum size of objects on the metadata tier of a tiered remote
+func (remote RemoteObject) GetTieredMaxMetadataSize() int64 {
+
+	if remote.TieredMaxMetadataSize == 0 {
+		return TieredMaxMetadataSizeDefault
+	} else if remote.TieredMaxMetadataSize < TieredMaxMetadataSizeMinimum {
+		return TieredMaxMetadataSizeMinimum
+	}
+	return remote.TieredMaxMetadataSize
 }
 
 func getConfigFile(dir string) string {
@@ -106,7 +227,7 @@ func LoadConfig(dir string) (bool, error) {
 	return true, nil
 }
 
-func SaveConfig(dir string, leafSize uint32, maxRepoSize uint64) error {
+func SaveConfig(dir string, leafSize uint32, maxRepoSize uint64, rollingHashBits, rollingHashMin int, compression string) error {
 
 	configObject := ConfigObject{Version: 1, Type: CONFIG, BasePath: dir}
 
@@ -126,21 +247,75 @@ func SaveConfig(dir string, leafSize uint32, maxRepoSize uint64) error {
 		configObject.MaxRepoSize = maxRepoSize
 	}
 
+	// Content defined chunking is enabled when the number of bits for the rolling hash is set
+	if rollingHashBits != 0 {
+		if rollingHashBits < RollingHashBitsMinimum {
+			configObject.RollingHashBits = RollingHashBitsMinimum
+		} else if rollingHashBits > RollingHashBitsMaximum {
+			configObject.RollingHashBits = RollingHashBitsMaximum
+		} else {
+			configObject.RollingHashBits = rollingHashBits
+		}
+
+		if rollingHashMin < RollingHashMinMinimum {
+			configObject.RollingHashMin = RollingHashMinMinimum
+		} else if uint32(rollingHashMin) >= configObject.LeafSize {
+			configObject.RollingHashMin = int(configObject.LeafSize / 2)
+		} else {
+			configObject.RollingHashMin = rollingHashMin
+		}
+	}
+
+	switch compression {
+	case COMPRESSION_NONE, COMPRESSION_SNAPPY, COMPRESSION_DEFLATE:
+		configObject.Compression = compression
+	default:
+		return errors.New(fmt.Sprintf("Unknown compression: %s", compression))
+	}
+
 	return saveConfig(configObject, []RemoteObject{})
 }
 
-func SaveConfigFromUrl(url, dir, accessKey, secretKey, endpoint string, leafSize uint32, maxRepoSize uint64) error {
+func SaveConfigFromUrl(name, url, dir, accessKey, secretKey, endpoint, provider, source string, leafSize uint32, maxRepoSize uint64) error {
+
+	if provider != "" && !IsCredentialProvider(provider) {
+		return errors.New(fmt.Sprintf("Unknown credential provider: %s", provider))
+	}
 
-	err := SaveConfig(dir, leafSize, maxRepoSize)
+	err := SaveConfig(dir, leafSize, maxRepoSize, 0, 0, COMPRESSION_NONE)
 	if err != nil {
 		return err
 	}
 
-	remote, err := CreateRemote("primary", url, accessKey, secretKey, endpoint)
+	remote, err := CreateRemote(name, url, accessKey, secretKey, endpoint)
 	if err != nil {
 		return err
 	}
 
+	// Credentials are for the shards (of a sharded, erasure coded or tiered remote)
+	if provider != "" && len(remote.Shards) > 0 {
+		for i := range remote.Shards {
+			remote.Shards[i].CredentialProvider, remote.Shards[i].CredentialSource = provider, source
+		}
+	} else if provider != "" {
+		remote.CredentialProvider, remote.CredentialSource = provider, source
+	}
+	DefaultCredentialProvider(remote)
+	err = StoreCredentials(remote)
+	if err != nil {
+		return err
+	}
+
+	// Do not modify an archive that is cloned from
+	if remote.Type == REMOTE_ARCHIVE {
+		remote.ArchiveReadOnly = true
+	}
+	for i := range remote.Shards {
+		if remote.Shards[i].Type == REMOTE_ARCHIVE {
+			remote.Shards[i].ArchiveReadOnly = true
+		}
+	}
+
 	err = AddRemote(remote)
 	if err != nil {
 		return err
@@ -162,8 +337,8 @@ func saveConfig(configObject ConfigObject, remotes []RemoteObject) error {
 		return err
 	}
 
-	// Save config to file
-	err := ioutil.WriteFile(getConfigFile(configObject.BasePath), buf.Bytes(), os.ModePerm)
+	// Save config to file (only accessible by owner as it may contain keys)
+	err := writeFilePrivate(getConfigFile(configObject.BasePath), buf.Bytes())
 	if err != nil {
 		return err
 	}
@@ -177,17 +352,51 @@ func saveConfig(configObject ConfigObject, remotes []RemoteObject) error {
 	return nil
 }
 
-func AddRemote(remote *RemoteObject) error {
+// Set the key for encrypting objects on the remote back end (hex encoded, empty to disable)
+func SetEncryptionKey(key string) error {
 
-	for _, r := range Config.Remotes {
-		if r.Name == remote.Name {
-			return errors.New(fmt.Sprintf("Remote already exists with name: %s", remote.Name))
+	if key != "" {
+		if _, err := decodeEncryptionKey(key); err != nil {
+			return err
 		}
 	}
 
-	// TODO: Remove restriction for just a single remote
-	if len(Config.Remotes) >= 1 {
-		return errors.New("Current restriction applies of one remote only (to be lifted)")
+	Config.EncryptionKey = key
+
+	return saveConfig(Config, []RemoteObject{})
+}
+
+// Get the key for encrypting objects from the environment or the config (nil when not encrypting)
+func GetEncryptionKey() ([]byte, error) {
+
+	key := os.Getenv(ENCRYPTION_KEY_ENV)
+	if key == "" {
+		key = Config.EncryptionKey
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	return decodeEncryptionKey(key)
+}
+
+func decodeEncryptionKey(key string) ([]byte, error) {
+
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Encryption key is not hex encoded: %v", err))
+	}
+	if len(b) != EncryptionKeySize {
+		return nil, errors.New(fmt.Sprintf("Encryption key must be %d bytes", EncryptionKeySize))
+	}
+
+	return b, nil
+}
+
+func AddRemote(remote *RemoteObject) error {
+
+	if nameInUse(remote.Name) {
+		return errors.New(fmt.Sprintf("Remote already exists with name: %s", remote.Name))
 	}
 
 	remotes := []RemoteObject{}
@@ -204,13 +413,222 @@ func AddFakeRemote(name, directory string) error {
 		}
 	}
 
-	// TODO: Remove restriction for just a single remote
-	if len(Config.Remotes) >= 1 {
-		return errors.New("Current restriction applies of one remote only (to be lifted)")
-	}
-
 	remotes := []RemoteObject{}
-	remotes = append(remotes, RemoteObject{Name: "fake", Type: REMOTE_FAKE, FakeDirectory: directory})
+	remotes = append(remotes, RemoteObject{Name: name, Type: REMOTE_FAKE, FakeDirectory: directory})
 
 	return saveConfig(Config, remotes)
 }
+
+// Remove a remote by name
+func RemoveRemote(name string) error {
+
+	index, err := findRemote(name)
+	if err != nil {
+		return err
+	}
+
+	// Remove keys of the remote and its shards from keyring (if it can be unlocked)
+	removeFromKeyring(Config.Remotes[index])
+
+	Config.Remotes = append(Config.Remotes[:index], Config.Remotes[index+1:]...)
+
+	order := []string{}
+	for _, n := range Config.RemoteOrder {
+		if n != name {
+			order = append(order, n)
+		}
+	}
+	Config.RemoteOrder = order
+
+	return saveConfig(Config, []RemoteObject{})
+}
+
+// Rename a remote
+func RenameRemote(name, newName string) error {
+
+	index, err := findRemote(name)
+	if err != nil {
+		return err
+	}
+	if nameInUse(newName) {
+		return errors.New(fmt.Sprintf("Remote already exists with name: %s", newName))
+	}
+
+	// Shards are named after the remote, move keys in keyring along
+	remote := &Config.Remotes[index]
+	renames := make(map[string]string)
+	if remote.CredentialProvider == CREDENTIALS_KEYRING {
+		renames[name] = newName
+	}
+	for i := range remote.Shards {
+		shardName := fmt.Sprintf("%s-%d", newName, i)
+		if remote.Shards[i].CredentialProvider == CREDENTIALS_KEYRING {
+			renames[remote.Shards[i].Name] = shardName
+		}
+		remote.Shards[i].Name = shardName
+	}
+	err = keyringRename(renames)
+	if err != nil {
+		return err
+	}
+
+	remote.Name = newName
+
+	for i, n := range Config.RemoteOrder {
+		if n == name {
+			Config.RemoteOrder[i] = newName
+		}
+	}
+
+	return saveConfig(Config, []RemoteObject{})
+}
+
+// Update a remote (matched by name)
+func UpdateRemote(remote *RemoteObject) error {
+
+	index, err := findRemote(remote.Name)
+	if err != nil {
+		return err
+	}
+
+	Config.Remotes[index] = *remote
+
+	return saveConfig(Config, []RemoteObject{})
+}
+
+// Add a read-only fallback remote (tried after all remotes and earlier alternates)
+func AddAlternate(alternate *RemoteObject) error {
+
+	if nameInUse(alternate.Name) {
+		return errors.New(fmt.Sprintf("Remote already exists with name: %s", alternate.Name))
+	}
+
+	// Never modify an archive that is read from
+	if alternate.Type == REMOTE_ARCHIVE {
+		alternate.ArchiveReadOnly = true
+	}
+	for i := range alternate.Shards {
+		if alternate.Shards[i].Type == REMOTE_ARCHIVE {
+			alternate.Shards[i].ArchiveReadOnly = true
+		}
+	}
+
+	Config.Alternates = append(Config.Alternates, *alternate)
+
+	return saveConfig(Config, []RemoteObject{})
+}
+
+// Remove a read-only fallback remote by name
+func RemoveAlternate(name string) error {
+
+	for i, a := range Config.Alternates {
+		if a.Name == name {
+
+			// Remove keys of the alternate and its shards from keyring (if it can be unlocked)
+			removeFromKeyring(a)
+
+			Config.Alternates = append(Config.Alternates[:i], Config.Alternates[i+1:]...)
+
+			return saveConfig(Config, []RemoteObject{})
+		}
+	}
+
+	return errors.New(fmt.Sprintf("No alternate found with name: %s", name))
+}
+
+// Remove the keys of a remote and its shards from the keyring (ignoring a locked keyring)
+func removeFromKeyring(remote RemoteObject) {
+
+	renames := make(map[string]string)
+	for _, name := range keyringNames(remote) {
+		renames[name] = ""
+	}
+	keyringRename(renames)
+}
+
+// Check whether a name is used by a remote or an alternate (which share the keyring)
+func nameInUse(name string) bool {
+
+	if _, err := findRemote(name); err == nil {
+		return true
+	}
+	for _, a := range Config.Alternates {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func findRemote(name string) (int, error) {
+
+	for i, r := range Config.Remotes {
+		if r.Name == name {
+			return i, nil
+		}
+	}
+
+	return -1, errors.New(fmt.Sprintf("No remote found with name: %s", name))
+}
+
+// Get a remote by name, or the default remote (the first remote that was added) when the name
+// is empty. The order for reads does not change the default remote for eg. pushing
+func GetRemote(name string) (*RemoteObject, error) {
+
+	remotes := Config.Remotes
+	if len(remotes) == 0 {
+		return nil, errors.New("No remotes configured")
+	}
+
+	if name == "" {
+		r := remotes[0]
+		return &r, nil
+	}
+
+	for _, r := range remotes {
+		if r.Name == name {
+			return &r, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("No remote found with name: %s", name))
+}
+
+// Get the remotes in the order in which they are tried for reads (remotes
+// that are not explicitly ordered follow in the order in which they were added)
+func GetRemotesInOrder() []RemoteObject {
+
+	remotes := make([]RemoteObject, 0, len(Config.Remotes))
+	ordered := make(map[string]bool)
+
+	for _, name := range Config.RemoteOrder {
+		for _, r := range Config.Remotes {
+			if r.Name == name && !ordered[name] {
+				remotes = append(remotes, r)
+				ordered[name] = true
+			}
+		}
+	}
+
+	for _, r := range Config.Remotes {
+		if !ordered[r.Name] {
+			remotes = append(remotes, r)
+		}
+	}
+
+	return remotes
+}
+
+// Set the order in which remotes are tried for reads
+func SetRemoteOrder(names []string) error {
+
+	for _, name := range names {
+		if _, err := findRemote(name); err != nil {
+			return err
+		}
+	}
+
+	Config.RemoteOrder = names
+
+	return saveConfig(Config, []RemoteObject{})
+}
@@ -0,0 +1,402 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"golang.org/x/crypto/scrypt"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// Providers for credentials of a remote (referenced by name in the config)
+const CREDENTIALS_STATIC = "static"   // Keys stored in the config (only when chosen explicitly)
+const CREDENTIALS_ENV = "env"         // AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables
+const CREDENTIALS_SHARED = "shared"   // AWS shared credentials file (with CredentialSource as profile)
+const CREDENTIALS_PROCESS = "process" // External command (CredentialSource) printing credentials as JSON
+const CREDENTIALS_KEYRING = "keyring" // Encrypted local keyring (unlocked with S3GIT_KEYRING_PASSPHRASE)
+
+const KEYRING = "keyring"
+const KEYRING_PASSPHRASE_ENV = "S3GIT_KEYRING_PASSPHRASE"
+
+const keyringSaltSize = 16
+const keyringNonceSize = 12
+
+// Get the credentials for a remote from its provider
+func NewCredentials(remote RemoteObject) *credentials.Credentials {
+
+	switch remote.CredentialProvider {
+	case CREDENTIALS_ENV:
+		return credentials.NewEnvCredentials()
+	case CREDENTIALS_SHARED:
+		return credentials.NewSharedCredentials("", remote.CredentialSource)
+	case CREDENTIALS_PROCESS:
+		return credentials.NewCredentials(&processProvider{command: remote.CredentialSource})
+	case CREDENTIALS_KEYRING:
+		return credentials.NewCredentials(&keyringProvider{name: remote.Name})
+	case CREDENTIALS_STATIC, "": // Remotes that were configured before there were providers have keys in the config
+		if remote.Type == REMOTE_DYNAMODB {
+			return credentials.NewStaticCredentials(remote.DynamoDbAccessKey, remote.DynamoDbSecretKey, "")
+		}
+		return credentials.NewStaticCredentials(remote.S3AccessKey, remote.S3SecretKey, "")
+	default:
+		return credentials.NewCredentials(&errorProvider{err: errors.New(fmt.Sprintf("Unknown credential provider: %s", remote.CredentialProvider))})
+	}
+}
+
+// Choose the credential provider for S3 and DynamoDB remotes (or their shards) for which
+// none is chosen: the keyring when keys are given, otherwise the environment
+func DefaultCredentialProvider(remote *RemoteObject) {
+
+	for i := range remote.Shards {
+		DefaultCredentialProvider(&remote.Shards[i])
+	}
+	if remote.CredentialProvider != "" {
+		return
+	}
+
+	switch remote.Type {
+	case REMOTE_S3:
+		remote.CredentialProvider = CREDENTIALS_ENV
+		if remote.S3AccessKey != "" || remote.S3SecretKey != "" {
+			remote.CredentialProvider = CREDENTIALS_KEYRING
+		}
+	case REMOTE_DYNAMODB:
+		remote.CredentialProvider = CREDENTIALS_ENV
+		if remote.DynamoDbAccessKey != "" || remote.DynamoDbSecretKey != "" {
+			remote.CredentialProvider = CREDENTIALS_KEYRING
+		}
+	}
+}
+
+// Move the keys of a remote (or its shards) into the keyring when using the keyring. Keys
+// are only kept in the config when using the static provider.
+func StoreCredentials(remote *RemoteObject) error {
+
+	for i := range remote.Shards {
+		err := StoreCredentials(&remote.Shards[i])
+		if err != nil {
+			return err
+		}
+	}
+	if remote.CredentialProvider == CREDENTIALS_STATIC || remote.CredentialProvider == "" {
+		return nil
+	}
+
+	accessKey, secretKey := remote.S3AccessKey, remote.S3SecretKey
+	if remote.Type == REMOTE_DYNAMODB {
+		accessKey, secretKey = remote.DynamoDbAccessKey, remote.DynamoDbSecretKey
+	}
+	if remote.CredentialProvider == CREDENTIALS_KEYRING && (accessKey != "" || secretKey != "") {
+		err := KeyringStore(remote.Name, accessKey, secretKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	remote.S3AccessKey, remote.S3SecretKey = "", ""
+	remote.DynamoDbAccessKey, remote.DynamoDbSecretKey = "", ""
+
+	return nil
+}
+
+// Check whether a credential provider is known
+func IsCredentialProvider(provider string) bool {
+
+	switch provider {
+	case CREDENTIALS_STATIC, CREDENTIALS_ENV, CREDENTIALS_SHARED, CREDENTIALS_PROCESS, CREDENTIALS_KEYRING:
+		return true
+	}
+	return false
+}
+
+type errorProvider struct {
+	err error
+}
+
+func (p *errorProvider) Retrieve() (credentials.Value, error) {
+	return credentials.Value{}, p.err
+}
+
+func (p *errorProvider) IsExpired() bool {
+	return true
+}
+
+// Provider that runs an external command which prints the credentials in the format of the
+// AWS 'credential_process' setting. The command is run directly (not by a shell), with its
+// arguments separated by white space.
+type processProvider struct {
+	command    string
+	expiration time.Time
+}
+
+type processOutput struct {
+	Version         int    `json:"Version"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+func (p *processProvider) Retrieve() (credentials.Value, error) {
+
+	argv := strings.Fields(p.command)
+	if len(argv) == 0 {
+		return credentials.Value{}, errors.New("No command for credential process")
+	}
+
+	out, err := exec.Command(argv[0], argv[1:]...).Output()
+	if err != nil {
+		return credentials.Value{}, errors.New(fmt.Sprintf("Credential process failed: %v", err))
+	}
+
+	var po processOutput
+	if err := json.Unmarshal(out, &po); err != nil {
+		return credentials.Value{}, errors.New(fmt.Sprintf("Bad output from credential process: %v", err))
+	}
+	if po.AccessKeyId == "" || po.SecretAccessKey == "" {
+		return credentials.Value{}, errors.New("Credential process did not return keys")
+	}
+
+	p.expiration = time.Time{}
+	if po.Expiration != "" {
+		p.expiration, err = time.Parse(time.RFC3339, po.Expiration)
+		if err != nil {
+			return credentials.Value{}, errors.New(fmt.Sprintf("Bad expiration from credential process: %v", err))
+		}
+	}
+
+	return credentials.Value{AccessKeyID: po.AccessKeyId, SecretAccessKey: po.SecretAccessKey, SessionToken: po.SessionToken, ProviderName: "process"}, nil
+}
+
+func (p *processProvider) IsExpired() bool {
+	return !p.expiration.IsZero() && time.Now().After(p.expiration)
+}
+
+// Provider that reads the keys for a remote from the encrypted keyring
+type keyringProvider struct {
+	name string
+}
+
+type keyringEntry struct {
+	AccessKey string `json:"AccessKey"`
+	SecretKey string `json:"SecretKey"`
+}
+
+func (p *keyringProvider) Retrieve() (credentials.Value, error) {
+
+	entries, err := readKeyring()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	entry, ok := entries[p.name]
+	if !ok {
+		return credentials.Value{}, errors.New(fmt.Sprintf("No keys in keyring for remote: %s", p.name))
+	}
+
+	return credentials.Value{AccessKeyID: entry.AccessKey, SecretAccessKey: entry.SecretKey, ProviderName: "keyring"}, nil
+}
+
+func (p *keyringProvider) IsExpired() bool {
+	return false
+}
+
+// Names under which the keys of a remote and its shards are stored in the keyring
+func keyringNames(remote RemoteObject) []string {
+
+	names := []string{}
+	if remote.CredentialProvider == CREDENTIALS_KEYRING {
+		names = append(names, remote.Name)
+	}
+	for _, shard := range remote.Shards {
+		names = append(names, keyringNames(shard)...)
+	}
+	return names
+}
+
+// Rename (or remove when the new name is empty) the keys of remotes in the keyring
+func keyringRename(renames map[string]string) error {
+
+	if len(renames) == 0 {
+		return nil
+	}
+
+	entries, err := readKeyring()
+	if err != nil {
+		return err
+	}
+
+	// Take all entries out first so that a new name can be the old name of another entry
+	moved := make(map[string]keyringEntry)
+	for name, newName := range renames {
+		if entry, ok := entries[name]; ok {
+			delete(entries, name)
+			if newName != "" {
+				moved[newName] = entry
+			}
+		}
+	}
+	for name, entry := range moved {
+		entries[name] = entry
+	}
+
+	return writeKeyring(entries)
+}
+
+// Store the keys for a remote in the encrypted keyring (empty keys remove the entry)
+func KeyringStore(name, accessKey, secretKey string) error {
+
+	entries, err := readKeyring()
+	if err != nil {
+		return err
+	}
+
+	if accessKey == "" && secretKey == "" {
+		delete(entries, name)
+	} else {
+		entries[name] = keyringEntry{AccessKey: accessKey, SecretKey: secretKey}
+	}
+
+	return writeKeyring(entries)
+}
+
+func getKeyringFile() string {
+	return path.Join(Config.BasePath, S3GIT_DIR, KEYRING)
+}
+
+// Derive the key for the keyring from the passphrase
+func keyringKey(salt []byte) ([]byte, error) {
+
+	passphrase := os.Getenv(KEYRING_PASSPHRASE_ENV)
+	if passphrase == "" {
+		return nil, errors.New(fmt.Sprintf("Keyring is locked, set %s", KEYRING_PASSPHRASE_ENV))
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// The keyring consists of a salt and nonce followed by the encrypted entries
+func readKeyring() (map[string]keyringEntry, error) {
+
+	entries := make(map[string]keyringEntry)
+
+	data, err := ioutil.ReadFile(getKeyringFile())
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(data) < keyringSaltSize+keyringNonceSize {
+		return nil, errors.New("Keyring is corrupt")
+	}
+
+	key, err := keyringKey(data[:keyringSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := data[keyringSaltSize:keyringSaltSize+keyringNonceSize]
+	plain, err := gcm.Open(nil, nonce, data[keyringSaltSize+keyringNonceSize:], nil)
+	if err != nil {
+		return nil, errors.New("Failed to unlock keyring (wrong passphrase?)")
+	}
+
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func writeKeyring(entries map[string]keyringEntry) error {
+
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, keyringSaltSize+keyringNonceSize)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+
+	key, err := keyringKey(data[:keyringSaltSize])
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := append([]byte{}, data[keyringSaltSize:]...)
+	data = gcm.Seal(data, nonce, plain, nil)
+
+	return writeFilePrivate(getKeyringFile(), data)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Write a file that is only accessible by the owner (also when it already exists). The data is
+// written to a temporary file (created as private) that is renamed into place
+func writeFilePrivate(filename string, data []byte) error {
+
+	f, err := ioutil.TempFile(path.Dir(filename), path.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // Fails once renamed
+
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
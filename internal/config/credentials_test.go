@@ -0,0 +1,172 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestConfigFileIsPrivate(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-config-")
+	defer os.RemoveAll(dir)
+
+	// Existing file that is world readable
+	ioutil.WriteFile(getConfigFile(dir), []byte("{}"), 0666)
+
+	err := SaveConfig(dir, 0, 0, 0, 0, COMPRESSION_NONE)
+	assert.Nil(t, err)
+
+	fi, err := os.Stat(getConfigFile(dir))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// No temporary files are left behind
+	files, _ := ioutil.ReadDir(path.Dir(getConfigFile(dir)))
+	for _, f := range files {
+		assert.NotContains(t, f.Name(), ".tmp")
+	}
+}
+
+func TestProcessCredentials(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-config-")
+	defer os.RemoveAll(dir)
+
+	// The command is not run by a shell, so quotes and other metacharacters are passed as is
+	script := path.Join(dir, "credentials.sh")
+	ioutil.WriteFile(script, []byte(`#!/bin/sh
+echo "{\"Version\": 1, \"AccessKeyId\": \"$1\", \"SecretAccessKey\": \"SECRET\"}"
+`), 0700)
+
+	remote := RemoteObject{Name: "primary", Type: REMOTE_S3, CredentialProvider: CREDENTIALS_PROCESS,
+		CredentialSource: script + " AKID"}
+
+	value, err := NewCredentials(remote).Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID", value.AccessKeyID)
+	assert.Equal(t, "SECRET", value.SecretAccessKey)
+
+	remote.CredentialSource = script + " ';touch " + path.Join(dir, "injected") + "'"
+	NewCredentials(remote).Get()
+	_, err = os.Stat(path.Join(dir, "injected"))
+	assert.True(t, os.IsNotExist(err))
+
+	remote.CredentialSource = path.Join(dir, "missing")
+	_, err = NewCredentials(remote).Get()
+	assert.NotNil(t, err)
+}
+
+func TestKeyringCredentials(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-config-")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(path.Join(dir, S3GIT_DIR), os.ModePerm)
+	SaveConfig(dir, 0, 0, 0, 0, COMPRESSION_NONE)
+
+	os.Setenv(KEYRING_PASSPHRASE_ENV, "correct horse battery staple")
+	defer os.Unsetenv(KEYRING_PASSPHRASE_ENV)
+
+	err := KeyringStore("primary", "AKID", "SECRET")
+	assert.Nil(t, err)
+
+	remote := RemoteObject{Name: "primary", Type: REMOTE_S3, CredentialProvider: CREDENTIALS_KEYRING}
+	value, err := NewCredentials(remote).Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID", value.AccessKeyID)
+	assert.Equal(t, "SECRET", value.SecretAccessKey)
+
+	// Keys are not stored in plaintext
+	data, _ := ioutil.ReadFile(getKeyringFile())
+	assert.NotContains(t, string(data), "SECRET")
+
+	os.Setenv(KEYRING_PASSPHRASE_ENV, "wrong")
+	_, err = NewCredentials(remote).Get()
+	assert.NotNil(t, err)
+
+	_, err = NewCredentials(RemoteObject{Name: "primary", CredentialProvider: "unknown"}).Get()
+	assert.NotNil(t, err)
+}
+
+func TestDefaultCredentialProvider(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-config-")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(path.Join(dir, S3GIT_DIR), os.ModePerm)
+	SaveConfig(dir, 0, 0, 0, 0, COMPRESSION_NONE)
+
+	os.Setenv(KEYRING_PASSPHRASE_ENV, "correct horse battery staple")
+	defer os.Unsetenv(KEYRING_PASSPHRASE_ENV)
+
+	// Keys go into the keyring, not into the config
+	remote := RemoteObject{Name: "primary", Type: REMOTE_S3, S3AccessKey: "AKID", S3SecretKey: "SECRET"}
+	DefaultCredentialProvider(&remote)
+	assert.Equal(t, CREDENTIALS_KEYRING, remote.CredentialProvider)
+	assert.Nil(t, StoreCredentials(&remote))
+	assert.Equal(t, "", remote.S3AccessKey)
+	assert.Equal(t, "", remote.S3SecretKey)
+
+	value, err := NewCredentials(remote).Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID", value.AccessKeyID)
+
+	// Without keys the environment is used
+	remote = RemoteObject{Name: "other", Type: REMOTE_S3}
+	DefaultCredentialProvider(&remote)
+	assert.Equal(t, CREDENTIALS_ENV, remote.CredentialProvider)
+
+	// Keys are only kept in the config for the static provider
+	remote = RemoteObject{Name: "static", Type: REMOTE_S3, CredentialProvider: CREDENTIALS_STATIC, S3AccessKey: "AKID", S3SecretKey: "SECRET"}
+	DefaultCredentialProvider(&remote)
+	assert.Nil(t, StoreCredentials(&remote))
+	assert.Equal(t, "AKID", remote.S3AccessKey)
+}
+
+func TestKeyringOfShardsFollowsRemote(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "s3git-config-")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(path.Join(dir, S3GIT_DIR), os.ModePerm)
+	SaveConfig(dir, 0, 0, 0, 0, COMPRESSION_NONE)
+
+	os.Setenv(KEYRING_PASSPHRASE_ENV, "correct horse battery staple")
+	defer os.Unsetenv(KEYRING_PASSPHRASE_ENV)
+
+	remote := RemoteObject{Name: "sharded", Type: REMOTE_SHARDED, Shards: []RemoteObject{
+		{Name: "sharded-0", Type: REMOTE_S3, S3AccessKey: "AKID0", S3SecretKey: "SECRET0"},
+		{Name: "sharded-1", Type: REMOTE_S3, S3AccessKey: "AKID1", S3SecretKey: "SECRET1"}}}
+	DefaultCredentialProvider(&remote)
+	assert.Nil(t, StoreCredentials(&remote))
+	assert.Nil(t, AddRemote(&remote))
+
+	assert.Nil(t, RenameRemote("sharded", "renamed"))
+	entries, err := readKeyring()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "AKID1", entries["renamed-1"].AccessKey)
+	_, ok := entries["sharded-0"]
+	assert.False(t, ok)
+
+	assert.Nil(t, RemoveRemote("renamed"))
+	entries, err = readKeyring()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+}
@@ -21,6 +21,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -31,7 +33,7 @@ const RegionDefault = "us-east-1"
 
 func CreateRemote(name, resource, accessKey, secretKey, endpoint string) (*RemoteObject, error) {
 
-	parts := strings.Split(resource, "://")
+	parts := strings.SplitN(resource, "://", 2)
 	if len(parts) != 2 {
 		return nil, errors.New(fmt.Sprintf("Bad resource (missing '://' separator): %s", resource))
 	}
@@ -55,9 +57,8 @@ func CreateRemote(name, resource, accessKey, secretKey, endpoint string) (*Remot
 			if err != nil {
 				return nil, errors.New(fmt.Sprintln(err, "Bad credentials?"))
 			}
-		} else {
-			// TODO: 'Ping' remote to check credentials
 		}
+		// Credentials are checked by pinging the remote once created
 
 		region = getEnvironmentValueIfUnspecified(region, "S3GIT_S3_REGION") // Allow to be overriden when set explicitly
 		region = getRegionDefaultIfUnspecified(region)
@@ -73,6 +74,85 @@ func CreateRemote(name, resource, accessKey, secretKey, endpoint string) (*Remot
 
 		remote = &RemoteObject{Name: name, Type: REMOTE_DYNAMODB, DynamoDbTable: table, DynamoDbRegion: region, DynamoDbAccessKey: accessKey, DynamoDbSecretKey: secretKey}
 
+	case REMOTE_FILE:
+
+		directory, err := filepath.Abs(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		remote = &RemoteObject{Name: name, Type: REMOTE_FILE, FileDirectory: directory}
+
+	case REMOTE_AZURE:
+
+		container := parts[1]
+
+		// Access key is the storage account and secret key the account key
+		accessKey = getEnvironmentValueIfUnspecified(accessKey, "S3GIT_AZURE_ACCOUNT")
+		secretKey = getEnvironmentValueIfUnspecified(secretKey, "S3GIT_AZURE_ACCOUNT_KEY")
+		endpoint = getEnvironmentValueIfUnspecified(endpoint, "S3GIT_AZURE_ENDPOINT")
+		sasToken := getEnvironmentValueIfUnspecified("", "S3GIT_AZURE_SAS_TOKEN")
+
+		remote = &RemoteObject{Name: name, Type: REMOTE_AZURE, AzureContainer: container, AzureAccount: accessKey, AzureAccountKey: secretKey, AzureSasToken: sasToken, AzureEndpoint: endpoint}
+
+	case REMOTE_ARCHIVE:
+
+		archivePath, err := filepath.Abs(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		remote = &RemoteObject{Name: name, Type: REMOTE_ARCHIVE, ArchivePath: archivePath}
+
+	case REMOTE_SHARDED:
+
+		// Comma separated list of resources, eg. sharded://s3://bucket-0,s3://bucket-1
+		shards, err := createShards(name, parts[1], accessKey, secretKey, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if len(shards) < 2 {
+			return nil, errors.New(fmt.Sprintf("Sharded remote needs at least two resources: %s", resource))
+		}
+
+		remote = &RemoteObject{Name: name, Type: REMOTE_SHARDED, Shards: shards}
+
+	case REMOTE_ERASURE:
+
+		// Number of data shards followed by the resources for the data and then parity shards,
+		// eg. erasure://2/s3://bucket-0,s3://bucket-1,s3://bucket-2 for 2 data and 1 parity shard
+		spec := strings.SplitN(parts[1], "/", 2)
+		dataShards, err := strconv.Atoi(spec[0])
+		if err != nil || len(spec) != 2 {
+			return nil, errors.New(fmt.Sprintf("Bad erasure coded resource (missing number of data shards): %s", resource))
+		}
+		shards, err := createShards(name, spec[1], accessKey, secretKey, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if dataShards < 1 || len(shards) <= dataShards || len(shards) > 256 {
+			return nil, errors.New(fmt.Sprintf("Erasure coded remote needs more resources than data shards (and at most 256): %s", resource))
+		}
+
+		remote = &RemoteObject{Name: name, Type: REMOTE_ERASURE, Shards: shards, ErasureDataShards: dataShards}
+
+	case REMOTE_TIERED:
+
+		// Resource for the metadata tier followed by the bulk tier, eg. tiered://dynamodb://table,s3://bucket
+		shards, err := createShards(name, parts[1], accessKey, secretKey, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if len(shards) != 2 {
+			return nil, errors.New(fmt.Sprintf("Tiered remote needs a metadata and a bulk resource: %s", resource))
+		}
+
+		remote = &RemoteObject{Name: name, Type: REMOTE_TIERED, Shards: shards}
+
+	case REMOTE_HTTP, REMOTE_HTTPS:
+
+		remote = &RemoteObject{Name: name, Type: REMOTE_HTTP, HttpUrl: strings.TrimRight(resource, "/")}
+
 	default:
 		return nil, errors.New(fmt.Sprintf("Unknown resource type: %s", parts[0]))
 	}
@@ -80,6 +160,27 @@ func CreateRemote(name, resource, accessKey, secretKey, endpoint string) (*Remot
 	return remote, nil
 }
 
+// Create the remotes for a comma separated list of resources (for a sharded, erasure coded or tiered remote)
+func createShards(name, resources, accessKey, secretKey, endpoint string) ([]RemoteObject, error) {
+
+	shards := []RemoteObject{}
+	for _, shardResource := range strings.Split(resources, ",") {
+		if shardResource == "" {
+			continue
+		}
+		shard, err := CreateRemote(fmt.Sprintf("%s-%d", name, len(shards)), shardResource, accessKey, secretKey, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if len(shard.Shards) > 0 {
+			return nil, errors.New(fmt.Sprintf("Remote cannot be nested in a sharded, erasure coded or tiered remote: %s", shardResource))
+		}
+		shards = append(shards, *shard)
+	}
+
+	return shards, nil
+}
+
 // Get the region for a bucket or return US Standard otherwise
 func getRegionForBucket(bucket, accessKey, secretKey string) (string, error) {
 
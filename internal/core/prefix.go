@@ -31,6 +31,8 @@ import (
 	"runtime"
 	"errors"
 	"github.com/s3git/s3git-go/internal/config"
+	"github.com/s3git/s3git-go/internal/metrics"
+	"time"
 )
 
 type prefixObject struct {
@@ -110,6 +112,10 @@ func StorePrefixObject(commitHash string) error {
 
 func mine(followMeHash string) (string, error) {
 
+	defer metrics.PrefixMiningDuration.Since("", time.Now())
+	end := metrics.StartSpan("core.mine")
+	defer end(nil)
+
 	prefixObject := makePrefixObject(followMeHash)
 
 	buf := new(bytes.Buffer)
@@ -115,10 +115,15 @@ func SnapshotCheckout(path, hash string, fWrite func(hash, filename string, perm
 // Warm the cache for a given snapshot by pulling blobs in parallel
 func warmCacheForCheckout(hash string) error {
 
-	if len(config.Config.Remotes) == 0 {
+	// Blobs are pulled from the remotes in order (followed by the alternates)
+	remotes := config.GetRemotesInOrder()
+	if len(remotes) == 0 && len(config.Config.Alternates) == 0 {
 		return nil
 	}
-	const pullBlobsRoutines = 50
+	pullBlobsRoutines := 50
+	if len(remotes) > 0 {
+		pullBlobsRoutines = remotes[0].Limits.GetConcurrency(pullBlobsRoutines)
+	}
 
 	var wgDirs, wgBlobs sync.WaitGroup
 	var chanDirs = make(chan string, pullBlobsRoutines*4)
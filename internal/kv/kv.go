@@ -21,8 +21,10 @@ import (
 	"fmt"
 	"github.com/s3git/s3git-go/internal/config"
 	"github.com/bmatsuo/lmdb-go/lmdb"
+	"github.com/s3git/s3git-go/internal/metrics"
 	"os"
 	"path"
+	"time"
 )
 
 // TODO: Use new transaction style for lmdb
@@ -47,12 +49,18 @@ var dbiLevel1Snapshots lmdb.DBI
 var dbiLevel0CacheSize lmdb.DBI
 var dbiLevel0StageSize lmdb.DBI
 
+// KV database with the sizes of the leaves of blobs (to find leaf boundaries without loading leaves)
+var dbiLevel1LeafSizes lmdb.DBI
+
 // KV database containing overview of added/removed blobs in stage
 var dbiStage lmdb.DBI
 
 // KV database that marks commits objects as being a parent commit
 var dbiLevel1CommitsIsParent lmdb.DBI
 
+// KV database with the upload IDs of multipart uploads in progress (to resume them)
+var dbiMultipartUploads lmdb.DBI
+
 func OpenDatabase() error {
 
 	mdbDir := path.Join(config.Config.BasePath, config.S3GIT_DIR, "mdb")
@@ -65,10 +73,10 @@ func OpenDatabase() error {
 	// TODO: Figure out proper size for lmdb
 	// TODO: Windows: max size is capped at 32
 	env.SetMapSize(1 << 36) // max file size
-	env.SetMaxDBs(10)       // up to 10 named databases
+	env.SetMaxDBs(16)       // up to 16 named databases
 	env.Open(mdbDir, 0, 0664)
 
-	err = env.Update(func(txn *lmdb.Txn) (err error) {
+	err = update(func(txn *lmdb.Txn) (err error) {
 
 		// overview of blobs in stage
 		dbiStage, err = txn.OpenDBI("stage", lmdb.Create)
@@ -108,12 +116,24 @@ func OpenDatabase() error {
 			return err
 		}
 
+		// sizes of the leaves of blobs
+		dbiLevel1LeafSizes, err = txn.OpenDBI("l1leafsizes", lmdb.Create)
+		if err != nil {
+			return err
+		}
+
 		// list of top most commits
 		dbiLevel1CommitsIsParent, err = txn.OpenDBI("l1commitsisparent", lmdb.Create)
 		if err != nil {
 			return err
 		}
 
+		// multipart uploads in progress
+		dbiMultipartUploads, err = txn.OpenDBI("multipartuploads", lmdb.Create)
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -134,7 +154,7 @@ func AddToStage(key string) error {
 
 	hx, _ := hex.DecodeString(key)
 
-	err := env.Update(func(txn *lmdb.Txn) (err error) {
+	err := update(func(txn *lmdb.Txn) (err error) {
 		return txn.Put(dbiStage, hx, nil, 0)
 	})
 	if err != nil {
@@ -151,7 +171,7 @@ func ClearStage() error {
 		return err
 	}
 
-	txn, _ := env.BeginTxn(nil, 0)
+	txn, _ := beginTxn(0)
 	for k := range list {
 		txn.Del(dbiStage, k, nil)
 	}
@@ -169,7 +189,7 @@ func MarkCommitAsParent(key string) error {
 
 	hx, _ := hex.DecodeString(key)
 
-	txn, _ := env.BeginTxn(nil, 0)
+	txn, _ := beginTxn(0)
 	txn.Put(dbiLevel1CommitsIsParent, hx, nil, 0)
 	txn.Commit()
 
@@ -178,7 +198,7 @@ func MarkCommitAsParent(key string) error {
 
 func CommitIsParent(key []byte) (bool, error) {
 
-	txn, _ := env.BeginTxn(nil, lmdb.Readonly)
+	txn, _ := beginTxn(lmdb.Readonly)
 	defer txn.Abort()
 
 	_, err := txn.Get(dbiLevel1CommitsIsParent, key)
@@ -246,9 +266,17 @@ func ListLevel1Blobs(query string) (<-chan []byte, error) {
 
 func GetLevel1BlobsStats() (uint64, error) {
 
-	txn, _ := env.BeginTxn(nil, lmdb.Readonly)
+	return GetLevel1Stats(BLOB)
+}
+
+// Get the number of entries for a given object type
+func GetLevel1Stats(objType string) (uint64, error) {
+
+	dbi := getDbForObjectType(objType)
+
+	txn, _ := beginTxn(lmdb.Readonly)
 	defer txn.Abort()
-	stats, err := txn.Stat(dbiLevel1Blobs)
+	stats, err := txn.Stat(*dbi)
 	if err != nil {
 		return 0, err
 	}
@@ -256,6 +284,80 @@ func GetLevel1BlobsStats() (uint64, error) {
 	return stats.Entries, nil
 }
 
+// Iterate over all blobs and compute the logical size as well as the number of blobs that are
+// hydrated locally versus the number of blobs that are (partially) only available remotely.
+// For blobs that are not fully available locally the size is based on the number of leaves
+// and the sizes of the local leaves (only possible for leaves of fixed size, otherwise the blob
+// does not add to the size).
+func GetLevel1BlobsSizeStats() (logicalSize, hydrated, remoteOnly uint64, err error) {
+
+	err = view(func(txn *lmdb.Txn) (err error) {
+		cur, err := txn.OpenCursor(dbiLevel1Blobs)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+
+		for {
+			_, leafHashes, err := cur.Get(nil, nil, lmdb.Next)
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			// No leaf hashes stored means the blob has not been pulled down
+			if len(leafHashes) == 0 {
+				remoteOnly++
+				continue
+			}
+
+			leaves := len(leafHashes) / leafKeySize
+
+			// Leaves are of a fixed size when all local leaves (except for the last) are of equal size
+			local, sizeLeaves, sizeLast, foundLast := true, uint64(0), uint32(0), false
+			stride, fixed := uint32(0), true
+			for i := 0; i < len(leafHashes); i += leafKeySize {
+				size, found, err := getLevel0LeafSize(txn, leafHashes[i:i+leafKeySize])
+				if err != nil {
+					return err
+				}
+				if !found {
+					local = false
+				}
+				sizeLeaves += uint64(size)
+				if i == len(leafHashes)-leafKeySize {
+					sizeLast, foundLast = size, found
+				} else if found {
+					if stride != 0 && size != stride {
+						fixed = false
+					}
+					stride = size
+				}
+			}
+
+			if local {
+				logicalSize += sizeLeaves
+				hydrated++
+			} else {
+				if foundLast && fixed && stride != 0 && sizeLast <= stride {
+					logicalSize += uint64(leaves-1)*uint64(stride) + uint64(sizeLast)
+				}
+				remoteOnly++
+			}
+		}
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return logicalSize, hydrated, remoteOnly, nil
+}
+
+// Size of a single leaf hash within the value of a level 1 entry
+const leafKeySize = 64
+
 const BLOB = "blob"
 const COMMIT = "commit"
 const PREFIX = "prefix"
@@ -285,7 +387,7 @@ func getDbForObjectType(objType string) *lmdb.DBI {
 func AddToLevel1(key, value []byte, objType string) error {
 
 	dbi := getDbForObjectType(objType)
-	txn, _ := env.BeginTxn(nil, 0)
+	txn, _ := beginTxn(0)
 	txn.Put(*dbi, key, value, 0)
 	txn.Commit()
 
@@ -295,7 +397,7 @@ func AddToLevel1(key, value []byte, objType string) error {
 func AddMultiToLevel1(keys, values [][]byte, objType string) error {
 
 	dbi := getDbForObjectType(objType)
-	txn, _ := env.BeginTxn(nil, 0)
+	txn, _ := beginTxn(0)
 	for index, key := range keys {
 		txn.Put(*dbi, key, values[index], 0)
 	}
@@ -307,7 +409,7 @@ func AddMultiToLevel1(keys, values [][]byte, objType string) error {
 // Get object of any type, return value and type
 func GetLevel1(key []byte) ([]byte, string, error) {
 
-	txn, _ := env.BeginTxn(nil, lmdb.Readonly)
+	txn, _ := beginTxn(lmdb.Readonly)
 	defer txn.Abort()
 
 	val, err := txn.Get(dbiLevel1Blobs, key)
@@ -358,7 +460,7 @@ func listMdb(dbi *lmdb.DBI, query string) (<-chan []byte, error) {
 		defer close(result)
 
 		// scan the database
-		txn, _ := env.BeginTxn(nil, lmdb.Readonly)
+		txn, _ := beginTxn(lmdb.Readonly)
 		defer txn.Abort()
 		cursor, _ := txn.OpenCursor(*dbi)
 		defer cursor.Close()
@@ -411,3 +513,30 @@ func listMdb(dbi *lmdb.DBI, query string) (<-chan []byte, error) {
 
 	return result, nil
 }
+
+// Run a read-write transaction (measured for metrics)
+func update(fn lmdb.TxnOp) error {
+
+	defer metrics.KvTransactionDuration.Since("update", time.Now())
+	metrics.KvTransactions.Add("update", 1)
+	return env.Update(fn)
+}
+
+// Run a read-only transaction (measured for metrics)
+func view(fn lmdb.TxnOp) error {
+
+	defer metrics.KvTransactionDuration.Since("view", time.Now())
+	metrics.KvTransactions.Add("view", 1)
+	return env.View(fn)
+}
+
+// Begin a transaction that is committed or aborted by the caller (counted for metrics)
+func beginTxn(flags uint) (*lmdb.Txn, error) {
+
+	if flags&lmdb.Readonly != 0 {
+		metrics.KvTransactions.Add("view", 1)
+	} else {
+		metrics.KvTransactions.Add("update", 1)
+	}
+	return env.BeginTxn(nil, flags)
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kv
+
+import (
+	"encoding/binary"
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+// Store the sizes of the leaves of a blob
+func AddLeafSizes(key []byte, sizes []uint32) error {
+
+	val := make([]byte, 4*len(sizes))
+	for i, size := range sizes {
+		binary.LittleEndian.PutUint32(val[4*i:], size)
+	}
+
+	return update(func(txn *lmdb.Txn) (err error) {
+		return txn.Put(dbiLevel1LeafSizes, key, val, 0)
+	})
+}
+
+// Get the sizes of the leaves of a blob (not found for blobs written before sizes were stored)
+func GetLeafSizes(key []byte) (sizes []uint32, found bool, err error) {
+
+	err = view(func(txn *lmdb.Txn) (err error) {
+		val, err := txn.Get(dbiLevel1LeafSizes, key)
+		if lmdb.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sizes = make([]uint32, len(val)/4)
+		for i := range sizes {
+			sizes[i] = binary.LittleEndian.Uint32(val[4*i:])
+		}
+		found = true
+		return nil
+	})
+	return
+}
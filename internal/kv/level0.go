@@ -38,7 +38,7 @@ func addLevel0(dbi *lmdb.DBI, hash string, size uint32) error {
 	val := make([]byte, 4)
 	binary.LittleEndian.PutUint32(val, size)
 
-	err := env.Update(func(txn *lmdb.Txn) (err error) {
+	err := update(func(txn *lmdb.Txn) (err error) {
 		return txn.Put(*dbi, hx, val, 0)
 	})
 	return err
@@ -51,7 +51,7 @@ func MoveLevel0FromStageToCache(hash string) error {
 	var val []byte
 
 	// First obtain current value
-	err := env.View(func(txn *lmdb.Txn) (err error) {
+	err := view(func(txn *lmdb.Txn) (err error) {
 		var err2 error
 		val, err2 = txn.Get(dbiLevel0StageSize, hx)
 		return err2
@@ -60,7 +60,7 @@ func MoveLevel0FromStageToCache(hash string) error {
 		return err
 	}
 
-	err = env.Update(func(txn *lmdb.Txn) (err error) {
+	err = update(func(txn *lmdb.Txn) (err error) {
 
 		var err2 error
 		// First delete from stage
@@ -93,7 +93,7 @@ func getLevel0Size(dbi *lmdb.DBI) (uint64, error) {
 
 	var size uint64
 
-	err := env.View(func(txn *lmdb.Txn) (err error) {
+	err := view(func(txn *lmdb.Txn) (err error) {
 		cur, err := txn.OpenCursor(*dbi)
 		if err != nil {
 			return err
@@ -121,11 +121,98 @@ func getLevel0Size(dbi *lmdb.DBI) (uint64, error) {
 	return size, nil
 }
 
+// Get the combined size of all unique leaves that are stored locally (in either stage or cache)
+func GetLevel0PhysicalSize() (uint64, error) {
+
+	var size uint64
+
+	err := view(func(txn *lmdb.Txn) (err error) {
+
+		for _, dbi := range []lmdb.DBI{dbiLevel0StageSize, dbiLevel0CacheSize} {
+			dbiSize, err := sumLevel0Unique(txn, dbi)
+			if err != nil {
+				return err
+			}
+			size += dbiSize
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// Sum the sizes of the leaves in a database, skipping leaves in cache that are counted for stage
+func sumLevel0Unique(txn *lmdb.Txn, dbi lmdb.DBI) (uint64, error) {
+
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+
+	var size uint64
+	for {
+		k, v, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if dbi == dbiLevel0CacheSize {
+			// Skip leaves already counted for stage
+			_, err := txn.Get(dbiLevel0StageSize, k)
+			if err == nil {
+				continue
+			} else if !lmdb.IsNotFound(err) {
+				return 0, err
+			}
+		}
+
+		size += uint64(binary.LittleEndian.Uint32(v))
+	}
+}
+
+// Get the size of a leaf when available locally (in either stage or cache)
+func GetLevel0Size(hash string) (size uint32, found bool, err error) {
+
+	hx, _ := hex.DecodeString(hash)
+
+	err = view(func(txn *lmdb.Txn) (err error) {
+		size, found, err = getLevel0LeafSize(txn, hx)
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return size, found, nil
+}
+
+func getLevel0LeafSize(txn *lmdb.Txn, key []byte) (uint32, bool, error) {
+
+	for _, dbi := range []lmdb.DBI{dbiLevel0StageSize, dbiLevel0CacheSize} {
+		val, err := txn.Get(dbi, key)
+		if err == nil {
+			return binary.LittleEndian.Uint32(val), true, nil
+		} else if !lmdb.IsNotFound(err) {
+			return 0, false, err
+		}
+	}
+
+	return 0, false, nil
+}
+
 func RemoveLevel0FromCache(hash string) error {
 
 	hx, _ := hex.DecodeString(hash)
 
-	err := env.Update(func(txn *lmdb.Txn) (err error) {
+	err := update(func(txn *lmdb.Txn) (err error) {
 
 		return txn.Del(dbiLevel0CacheSize, hx, nil)
 	})
@@ -140,7 +227,7 @@ func GetLevel0RandomListFromCache() ([]string, error) {
 
 	var entries uint64
 
-	err := env.View(func(txn *lmdb.Txn) (err error) {
+	err := view(func(txn *lmdb.Txn) (err error) {
 		stats, err := txn.Stat(dbiLevel0CacheSize)
 		if err != nil {
 			return err
@@ -158,7 +245,7 @@ func GetLevel0RandomListFromCache() ([]string, error) {
 	itemsToSkip := entries / 100
 
 	var result []string
-	err = env.View(func(txn *lmdb.Txn) (err error) {
+	err = view(func(txn *lmdb.Txn) (err error) {
 		cur, err := txn.OpenCursor(dbiLevel0CacheSize)
 		if err != nil {
 			return err
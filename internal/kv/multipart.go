@@ -0,0 +1,56 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kv
+
+import (
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+// Get the upload ID of a multipart upload in progress (for a key that identifies the back end and object)
+func GetMultipartUpload(key string) (uploadId string, found bool, err error) {
+
+	err = view(func(txn *lmdb.Txn) (err error) {
+		val, err := txn.Get(dbiMultipartUploads, []byte(key))
+		if lmdb.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		uploadId, found = string(val), true
+		return nil
+	})
+	return
+}
+
+func AddMultipartUpload(key, uploadId string) error {
+
+	return update(func(txn *lmdb.Txn) (err error) {
+		return txn.Put(dbiMultipartUploads, []byte(key), []byte(uploadId), 0)
+	})
+}
+
+// Remove a multipart upload once it is completed or aborted
+func RemoveMultipartUpload(key string) error {
+
+	return update(func(txn *lmdb.Txn) (err error) {
+		err = txn.Del(dbiMultipartUploads, []byte(key), nil)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metrics keeps counters and histograms for operations of the back ends, the
+// content addressable storage and the key value store. Measurements are kept in memory
+// (to take snapshots) and forwarded to a pluggable sink. Operations can also be traced.
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sink receives every measurement (eg. to forward to a monitoring system)
+type Sink interface {
+	// Add to a counter
+	Add(name, labelName, labelValue string, delta float64)
+	// Add an observation to a histogram
+	Observe(name, labelName, labelValue string, value float64)
+}
+
+// Tracer starts a span for an operation, the returned function ends the span
+type Tracer interface {
+	StartSpan(operation string) (end func(err error))
+}
+
+// Upper bounds of the histogram buckets (in seconds)
+var Buckets = []float64{0.0001, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+const (
+	COUNTER   = "counter"
+	HISTOGRAM = "histogram"
+)
+
+type Metric struct {
+	Name      string
+	Help      string
+	Type      string
+	LabelName string // Empty when the metric has no label
+}
+
+var (
+	BackendRequests = &Metric{Name: "s3git_backend_requests_total", Help: "Number of back end operations.", Type: COUNTER, LabelName: "op"}
+	BackendErrors   = &Metric{Name: "s3git_backend_errors_total", Help: "Number of back end operations that failed.", Type: COUNTER, LabelName: "op"}
+	BackendRetries  = &Metric{Name: "s3git_backend_retries_total", Help: "Number of retried back end operations.", Type: COUNTER, LabelName: "op"}
+	BackendDuration = &Metric{Name: "s3git_backend_duration_seconds", Help: "Duration of back end operations.", Type: HISTOGRAM, LabelName: "op"}
+
+	CasFlushBytes    = &Metric{Name: "s3git_cas_flush_bytes_total", Help: "Number of bytes of leaves written to the cache.", Type: COUNTER}
+	CasFlushDuration = &Metric{Name: "s3git_cas_flush_duration_seconds", Help: "Duration of hashing and writing a leaf.", Type: HISTOGRAM}
+	CasLeafReads     = &Metric{Name: "s3git_cas_leaf_reads_total", Help: "Number of leaves read, by whether the leaf was in the cache.", Type: COUNTER, LabelName: "cache"}
+
+	KvTransactions        = &Metric{Name: "s3git_kv_transactions_total", Help: "Number of transactions of the key value store.", Type: COUNTER, LabelName: "type"}
+	KvTransactionDuration = &Metric{Name: "s3git_kv_transaction_duration_seconds", Help: "Duration of transactions of the key value store.", Type: HISTOGRAM, LabelName: "type"}
+
+	PrefixMiningDuration = &Metric{Name: "s3git_prefix_mining_duration_seconds", Help: "Duration of mining a prefix object.", Type: HISTOGRAM}
+)
+
+// Value of a metric (for a label)
+type Value struct {
+	*Metric
+	LabelValue string
+	Count      uint64   // Number of observations (for histograms)
+	Sum        float64  // Value of a counter or sum of the observations of a histogram
+	Buckets    []uint64 // Cumulative number of observations per bucket (for histograms)
+}
+
+type key struct {
+	metric     *Metric
+	labelValue string
+}
+
+var (
+	mutex  sync.Mutex
+	values = make(map[key]*Value)
+	sink   Sink
+	tracer Tracer
+)
+
+// Set the sink that receives all measurements (nil to remove)
+func SetSink(s Sink) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sink = s
+}
+
+// Set the tracer for operations (nil to remove)
+func SetTracer(t Tracer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	tracer = t
+}
+
+func getValue(m *Metric, labelValue string) *Value {
+
+	k := key{metric: m, labelValue: labelValue}
+	v, ok := values[k]
+	if !ok {
+		v = &Value{Metric: m, LabelValue: labelValue}
+		if m.Type == HISTOGRAM {
+			v.Buckets = make([]uint64, len(Buckets))
+		}
+		values[k] = v
+	}
+	return v
+}
+
+// Add to a counter
+func (m *Metric) Add(labelValue string, delta float64) {
+
+	mutex.Lock()
+	getValue(m, labelValue).Sum += delta
+	s := sink
+	mutex.Unlock()
+
+	if s != nil {
+		s.Add(m.Name, m.LabelName, labelValue, delta)
+	}
+}
+
+// Add an observation to a histogram
+func (m *Metric) Observe(labelValue string, value float64) {
+
+	mutex.Lock()
+	v := getValue(m, labelValue)
+	v.Count++
+	v.Sum += value
+	for i, bound := range Buckets {
+		if value <= bound {
+			v.Buckets[i]++
+		}
+	}
+	s := sink
+	mutex.Unlock()
+
+	if s != nil {
+		s.Observe(m.Name, m.LabelName, labelValue, value)
+	}
+}
+
+// Observe the duration since the start
+func (m *Metric) Since(labelValue string, start time.Time) {
+	m.Observe(labelValue, time.Since(start).Seconds())
+}
+
+// Start a span for an operation (when a tracer is set)
+func StartSpan(operation string) (end func(err error)) {
+
+	mutex.Lock()
+	t := tracer
+	mutex.Unlock()
+
+	if t == nil {
+		return func(err error) {}
+	}
+	return t.StartSpan(operation)
+}
+
+// Get a copy of all values (sorted by name and label)
+func Snapshot() []Value {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	snapshot := make([]Value, 0, len(values))
+	for _, v := range values {
+		c := *v
+		c.Buckets = append([]uint64(nil), v.Buckets...)
+		snapshot = append(snapshot, c)
+	}
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Name != snapshot[j].Name {
+			return snapshot[i].Name < snapshot[j].Name
+		}
+		return snapshot[i].LabelValue < snapshot[j].LabelValue
+	})
+
+	return snapshot
+}
+
+// Reset all values
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	values = make(map[key]*Value)
+}
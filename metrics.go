@@ -0,0 +1,141 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package s3git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/s3git/s3git-go/internal/metrics"
+)
+
+type MetricBucket struct {
+	UpperBound float64
+	Count      uint64 // Cumulative number of observations up to the upper bound
+}
+
+type MetricValue struct {
+	Name       string
+	Help       string
+	Type       string // "counter" or "histogram"
+	Label      string // Empty when the metric has no label
+	LabelValue string
+	Count      uint64  // Number of observations (for histograms)
+	Sum        float64 // Value of a counter or sum of the observations of a histogram
+	Buckets    []MetricBucket
+}
+
+type Metrics struct {
+	Values []MetricValue
+}
+
+// Receives every measurement (eg. to forward to StatsD), see metrics/prometheus for a
+// collector to register with a Prometheus registry instead
+type MetricsSink interface {
+	Add(name, labelName, labelValue string, delta float64)
+	Observe(name, labelName, labelValue string, value float64)
+}
+
+// Starts spans for back end operations and prefix mining (see metrics/otel for OpenTelemetry)
+type Tracer interface {
+	StartSpan(operation string) (end func(err error))
+}
+
+// Get a snapshot of the metrics. Metrics are kept for the process as a whole (like the
+// configuration and key value store), so they cover all repositories used by the process
+func ProcessMetrics() Metrics {
+
+	snapshot := metrics.Snapshot()
+
+	m := Metrics{Values: make([]MetricValue, 0, len(snapshot))}
+	for _, v := range snapshot {
+		value := MetricValue{Name: v.Name, Help: v.Help, Type: v.Type, Label: v.LabelName, LabelValue: v.LabelValue, Count: v.Count, Sum: v.Sum}
+		for i, count := range v.Buckets {
+			value.Buckets = append(value.Buckets, MetricBucket{UpperBound: metrics.Buckets[i], Count: count})
+		}
+		m.Values = append(m.Values, value)
+	}
+
+	return m
+}
+
+// Set the sink that receives every measurement (nil to remove)
+func SetMetricsSink(sink MetricsSink) {
+	metrics.SetSink(sink)
+}
+
+// Set the tracer for operations (nil to remove)
+func SetTracer(tracer Tracer) {
+	metrics.SetTracer(tracer)
+}
+
+// Write the metrics in the Prometheus text exposition format
+func (m Metrics) WritePrometheus(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+	previous := ""
+	for _, v := range m.Values {
+		if v.Name != previous {
+			fmt.Fprintf(bw, "# HELP %s %s\n", v.Name, v.Help)
+			fmt.Fprintf(bw, "# TYPE %s %s\n", v.Name, v.Type)
+			previous = v.Name
+		}
+
+		if v.Type == metrics.HISTOGRAM {
+			for _, b := range v.Buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", v.Name, labels(v, strconv.FormatFloat(b.UpperBound, 'g', -1, 64)), b.Count)
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", v.Name, labels(v, "+Inf"), v.Count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", v.Name, labels(v, ""), strconv.FormatFloat(v.Sum, 'g', -1, 64))
+			fmt.Fprintf(bw, "%s_count%s %d\n", v.Name, labels(v, ""), v.Count)
+		} else {
+			fmt.Fprintf(bw, "%s%s %s\n", v.Name, labels(v, ""), strconv.FormatFloat(v.Sum, 'g', -1, 64))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func labels(v MetricValue, le string) string {
+
+	pairs := ""
+	if v.Label != "" {
+		pairs = fmt.Sprintf("%s=%s", v.Label, strconv.Quote(v.LabelValue))
+	}
+	if le != "" {
+		if pairs != "" {
+			pairs += ","
+		}
+		pairs += fmt.Sprintf("le=%s", strconv.Quote(le))
+	}
+	if pairs == "" {
+		return ""
+	}
+	return "{" + pairs + "}"
+}
+
+// Handler to serve the metrics for scraping by Prometheus (eg. at /metrics)
+func MetricsHandler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		ProcessMetrics().WritePrometheus(w)
+	})
+}
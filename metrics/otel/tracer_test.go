@@ -0,0 +1,67 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testSpan struct {
+	trace.Span
+	name   string
+	err    error
+	status codes.Code
+	ended  bool
+}
+
+func (s *testSpan) End(options ...trace.SpanEndOption)                  { s.ended = true }
+func (s *testSpan) RecordError(err error, options ...trace.EventOption) { s.err = err }
+func (s *testSpan) SetStatus(code codes.Code, description string)       { s.status = code }
+
+type testTracer struct {
+	trace.Tracer
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &testSpan{name: spanName}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestStartSpan(t *testing.T) {
+
+	tt := &testTracer{}
+	tracer := NewTracer(tt)
+
+	tracer.StartSpan("backend.upload")(nil)
+	tracer.StartSpan("backend.download")(errors.New("failed"))
+
+	assert.Equal(t, 2, len(tt.spans))
+	assert.Equal(t, "backend.upload", tt.spans[0].name)
+	assert.True(t, tt.spans[0].ended)
+	assert.Nil(t, tt.spans[0].err)
+	assert.Equal(t, codes.Error, tt.spans[1].status)
+	assert.NotNil(t, tt.spans[1].err)
+	assert.True(t, tt.spans[1].ended)
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package prometheus exposes the metrics of s3git through a Prometheus collector. It is kept
+// in a separate package so that s3git itself does not depend on the Prometheus client.
+//
+//	prometheus.MustRegister(s3gitprometheus.NewCollector())
+package prometheus
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/s3git/s3git-go"
+)
+
+// Collector for the (process wide) metrics of s3git. It is an unchecked collector, as metrics
+// only appear once they are measured.
+type Collector struct {
+	metrics func() s3git.Metrics
+}
+
+func NewCollector() *Collector {
+	return &Collector{metrics: s3git.ProcessMetrics}
+}
+
+// Describe sends no descriptors (which makes it an unchecked collector)
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+}
+
+// Collect sends the current values of the metrics
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+
+	for _, v := range c.metrics().Values {
+
+		var labelNames, labelValues []string
+		if v.Label != "" {
+			labelNames, labelValues = []string{v.Label}, []string{v.LabelValue}
+		}
+		desc := prom.NewDesc(v.Name, v.Help, labelNames, nil)
+
+		if v.Type == "histogram" {
+			buckets := make(map[float64]uint64, len(v.Buckets))
+			for _, b := range v.Buckets {
+				buckets[b.UpperBound] = b.Count
+			}
+			ch <- prom.MustNewConstHistogram(desc, v.Count, v.Sum, buckets, labelValues...)
+		} else {
+			ch <- prom.MustNewConstMetric(desc, prom.CounterValue, v.Sum, labelValues...)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/s3git/s3git-go"
+)
+
+func TestCollect(t *testing.T) {
+
+	c := &Collector{metrics: func() s3git.Metrics {
+		return s3git.Metrics{Values: []s3git.MetricValue{
+			{Name: "s3git_backend_requests_total", Help: "Number of back end operations.", Type: "counter", Label: "op", LabelValue: "upload", Sum: 3},
+			{Name: "s3git_prefix_mining_duration_seconds", Help: "Duration of mining a prefix object.", Type: "histogram", Count: 2, Sum: 0.5,
+				Buckets: []s3git.MetricBucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}}},
+		}}
+	}}
+
+	ch := make(chan prom.Metric, 10)
+	c.Collect(ch)
+	close(ch)
+
+	var names []string
+	for m := range ch {
+		names = append(names, m.Desc().String())
+	}
+	assert.Equal(t, 2, len(names))
+	assert.True(t, strings.Contains(names[0], "s3git_backend_requests_total"))
+	assert.True(t, strings.Contains(names[1], "s3git_prefix_mining_duration_seconds"))
+}
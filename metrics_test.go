@@ -0,0 +1,108 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package s3git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSink struct {
+	mutex    sync.Mutex
+	counters map[string]float64
+}
+
+func (s *testSink) Add(name, labelName, labelValue string, delta float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.counters[name+"/"+labelValue] += delta
+}
+
+func (s *testSink) Observe(name, labelName, labelValue string, value float64) {}
+
+type testTracer struct {
+	mutex sync.Mutex
+	spans []string
+}
+
+func (t *testTracer) StartSpan(operation string) func(err error) {
+	return func(err error) {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		t.spans = append(t.spans, operation)
+	}
+}
+
+func getMetricValue(m Metrics, name, labelValue string) (MetricValue, bool) {
+	for _, v := range m.Values {
+		if v.Name == name && v.LabelValue == labelValue {
+			return v, true
+		}
+	}
+	return MetricValue{}, false
+}
+
+func TestMetrics(t *testing.T) {
+
+	fileDir, _ := ioutil.TempDir("", "s3git-file-backend-")
+	defer os.RemoveAll(fileDir)
+
+	sink, tracer := &testSink{counters: make(map[string]float64)}, &testTracer{}
+	SetMetricsSink(sink)
+	SetTracer(tracer)
+	defer SetMetricsSink(nil)
+	defer SetTracer(nil)
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+	assert.Nil(t, repo.RemoteAdd("usb", "file://" + fileDir, "", ""))
+
+	before, _ := getMetricValue(ProcessMetrics(), "s3git_backend_requests_total", "upload")
+
+	repo.Add(strings.NewReader("hello s3git: metrics"))
+	repo.Commit("1st commit")
+	assert.Nil(t, repo.Push(false, func(total int64) {}))
+
+	m := ProcessMetrics()
+	uploads, ok := getMetricValue(m, "s3git_backend_requests_total", "upload")
+	assert.True(t, ok)
+	assert.True(t, uploads.Sum >= before.Sum+4, "Expected blob, tree, commit and prefix to be uploaded")
+	assert.Equal(t, uploads.Sum-before.Sum, sink.counters["s3git_backend_requests_total/upload"])
+
+	duration, ok := getMetricValue(m, "s3git_backend_duration_seconds", "upload")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(uploads.Sum), duration.Count)
+
+	_, ok = getMetricValue(m, "s3git_prefix_mining_duration_seconds", "")
+	assert.True(t, ok)
+	_, ok = getMetricValue(m, "s3git_kv_transactions_total", "update")
+	assert.True(t, ok)
+	assert.Contains(t, tracer.spans, "backend.upload")
+	assert.Contains(t, tracer.spans, "core.mine")
+
+	var buf bytes.Buffer
+	assert.Nil(t, m.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "# TYPE s3git_backend_duration_seconds histogram\n")
+	assert.Contains(t, buf.String(), "s3git_backend_duration_seconds_bucket{op=\"upload\",le=\"+Inf\"} ")
+	assert.Contains(t, buf.String(), "s3git_cas_flush_bytes_total ")
+}
@@ -0,0 +1,370 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package s3git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/s3git/s3git-go/internal/backend"
+	"github.com/s3git/s3git-go/internal/cas"
+	"github.com/s3git/s3git-go/internal/core"
+)
+
+// Maximum size of an object that is held in memory to test whether it is in deduped format
+const mirrorPeekSize = 1024 * 1024
+
+// Number of blobs that are copied in parallel
+const mirrorBlobsRoutines = 8
+
+type mirrorOptions struct {
+	progress func(maxTicks int64)
+}
+
+// Report progress per prefix object that is mirrored
+func MirrorOptionSetProgress(progress func(maxTicks int64)) func(optns *mirrorOptions) {
+	return func(optns *mirrorOptions) {
+		optns.progress = progress
+	}
+}
+
+type MirrorOptions func(*mirrorOptions)
+
+// Copy all objects that are missing on the destination remote from the source remote
+// (objects are streamed through directly and are not stored in the local repository)
+func (repo Repository) Mirror(srcRemote, dstRemote string, options ...MirrorOptions) error {
+
+	optns := &mirrorOptions{progress: func(maxTicks int64) {}}
+	for _, op := range options {
+		op(optns)
+	}
+
+	src, err := backend.GetClient(srcRemote)
+	if err != nil {
+		return err
+	}
+
+	dst, err := backend.GetClient(dstRemote)
+	if err != nil {
+		return err
+	}
+
+	prefixesInSrc, err := listPrefixes(src)
+	if err != nil {
+		return err
+	}
+
+	prefixesInDst, err := listPrefixes(dst)
+	if err != nil {
+		return err
+	}
+
+	prefixesToMirror := []string{}
+	for prefix := range prefixesInSrc {
+		if _, ok := prefixesInDst[prefix]; !ok {
+			prefixesToMirror = append(prefixesToMirror, prefix)
+		}
+	}
+
+	if len(prefixesToMirror) == 0 {
+		return nil
+	}
+
+	optns.progress(int64(len(prefixesToMirror)))
+
+	for _, prefix := range prefixesToMirror {
+
+		err = mirrorPrefix(prefix, src, dst)
+		if err != nil {
+			return err
+		}
+
+		optns.progress(int64(len(prefixesToMirror)))
+	}
+
+	return nil
+}
+
+// Mirror prefix object and all objects directly and indirectly referenced by it
+func mirrorPrefix(prefix string, src, dst backend.Backend) error {
+
+	// Same order as for push: blobs, tree and snapshot, commit and finally the prefix
+	// object (so an interrupted mirror is picked up again during the next run)
+	prefixBytes, err := downloadObject(prefix, src)
+	if err != nil {
+		return err
+	}
+
+	po, err := core.GetPrefixObjectFromString(string(prefixBytes))
+	if err != nil {
+		return err
+	}
+
+	commitBytes, err := downloadObject(po.S3gitFollowMe, src)
+	if err != nil {
+		return err
+	}
+
+	co, err := core.GetCommitObjectFromString(string(commitBytes))
+	if err != nil {
+		return err
+	}
+
+	if co.S3gitTree != "" {
+		treeBytes, err := downloadObject(co.S3gitTree, src)
+		if err != nil {
+			return err
+		}
+
+		to, err := core.GetTreeObjectFromString(string(treeBytes))
+		if err != nil {
+			return err
+		}
+
+		err = mirrorBlobs(to.S3gitAdded, src, dst)
+		if err != nil {
+			return err
+		}
+
+		err = uploadObject(co.S3gitTree, treeBytes, dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	if co.S3gitSnapshot != "" {
+		err = mirrorSnapshotWithChildren(co.S3gitSnapshot, src, dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = uploadObject(po.S3gitFollowMe, commitBytes, dst)
+	if err != nil {
+		return err
+	}
+
+	return uploadObject(prefix, prefixBytes, dst)
+}
+
+func mirrorSnapshotWithChildren(hash string, src, dst backend.Backend) error {
+
+	verified, err := dst.VerifyHash(hash)
+	if err != nil {
+		return err
+	} else if verified {
+		return nil
+	}
+
+	snapshotBytes, err := downloadObject(hash, src)
+	if err != nil {
+		return err
+	}
+
+	so, err := core.GetSnapshotObjectFromString(string(snapshotBytes))
+	if err != nil {
+		return err
+	}
+
+	blobs := []string{}
+	for _, entry := range so.S3gitEntries {
+		if entry.IsDirectory() {
+			err = mirrorSnapshotWithChildren(entry.Blob, src, dst)
+			if err != nil {
+				return err
+			}
+		} else {
+			blobs = append(blobs, entry.Blob)
+		}
+	}
+
+	err = mirrorBlobs(blobs, src, dst)
+	if err != nil {
+		return err
+	}
+
+	return uploadObject(hash, snapshotBytes, dst)
+}
+
+// Mirror a list of blobs in parallel
+func mirrorBlobs(hashes []string, src, dst backend.Backend) error {
+
+	var wg sync.WaitGroup
+	var chanHashes = make(chan string)
+	var chanErrors = make(chan error, mirrorBlobsRoutines)
+
+	for i := 0; i < mirrorBlobsRoutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hash := range chanHashes {
+				if err := mirrorBlob(hash, src, dst); err != nil {
+					chanErrors <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+loop:
+	for _, hash := range hashes {
+		select {
+		case chanHashes <- hash:
+		case err = <-chanErrors:
+			break loop
+		}
+	}
+	close(chanHashes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-chanErrors:
+		default:
+		}
+	}
+	return err
+}
+
+// Mirror a blob, including its leaves when it is stored in deduped format
+func mirrorBlob(hash string, src, dst backend.Backend) error {
+
+	verified, err := dst.VerifyHash(hash)
+	if err != nil {
+		return err
+	} else if verified {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(src.DownloadRange(hash, 0, math.MaxInt64, pw))
+	}()
+	defer pr.Close()
+
+	// Peek at the start of the object
+	peek := make([]byte, mirrorPeekSize)
+	n, err := io.ReadFull(pr, peek)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Small object, leaves need to go first in case it is deduped
+		contents := peek[:n]
+		deduped, leaves, err := cas.GetDedupedLeaves(hash, contents)
+		if err != nil {
+			return err
+		}
+		if deduped {
+			err = mirrorLeaves(leaves, src, dst)
+			if err != nil {
+				return err
+			}
+		}
+		return uploadObject(hash, contents, dst)
+	} else if err != nil {
+		return err
+	}
+
+	// Large object, spool to a temporary file (outside of the cache) while computing the
+	// root hash so that leaves can still go first in case it turns out to be deduped
+	tmp, err := ioutil.TempFile("", "s3git-mirror-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	rootHasher := cas.NewRootHasher()
+	size, err := io.Copy(io.MultiWriter(tmp, rootHasher), io.MultiReader(bytes.NewReader(peek), pr))
+	if err != nil {
+		return err
+	}
+
+	if size%cas.KeySize == 0 && hex.EncodeToString(rootHasher.Sum(nil)) == hash {
+		// Deduped blob with many leaves, copy leaves in batches
+		leafHashes := make([]byte, cas.KeySize*1024)
+		for offset := int64(0); offset < size; offset += int64(len(leafHashes)) {
+			n, err := tmp.ReadAt(leafHashes, offset)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			leaves := []string{}
+			for i := 0; i < n; i += cas.KeySize {
+				leaves = append(leaves, hex.EncodeToString(leafHashes[i:i+cas.KeySize]))
+			}
+			err = mirrorLeaves(leaves, src, dst)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tmp.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+
+	return dst.UploadWithReader(hash, tmp)
+}
+
+func mirrorLeaves(leaves []string, src, dst backend.Backend) error {
+
+	for _, leaf := range leaves {
+		verified, err := dst.VerifyHash(leaf)
+		if err != nil {
+			return err
+		} else if verified {
+			continue
+		}
+
+		var buf bytes.Buffer
+		err = src.DownloadRange(leaf, 0, math.MaxInt64, &buf)
+		if err != nil {
+			return err
+		}
+
+		err = uploadObject(leaf, buf.Bytes(), dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Download a (small) object into memory
+func downloadObject(hash string, client backend.Backend) ([]byte, error) {
+
+	var buf bytes.Buffer
+	err := client.DownloadRange(hash, 0, math.MaxInt64, &buf)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to download %s: %s", hash, err))
+	}
+
+	return buf.Bytes(), nil
+}
+
+func uploadObject(hash string, contents []byte, client backend.Backend) error {
+
+	return client.UploadWithReader(hash, bytes.NewReader(contents))
+}
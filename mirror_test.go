@@ -0,0 +1,88 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package s3git
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+
+	primaryDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(primaryDir)
+	mirrorDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(mirrorDir)
+
+	path, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path)
+	repo, _ := InitRepository(path, InitOptionSetLeafSize(4096))
+	repo.remoteAddFake("primary", primaryDir)
+	repo.remoteAddFake("mirror", mirrorDir)
+
+	content := strings.Repeat("mirror", 2000)
+	hash, _, _ := repo.Add(strings.NewReader(content))
+	repo.Commit("1st commit")
+
+	err := repo.Push(false, func(total int64) {})
+	assert.Nil(t, err)
+
+	// Large blob that does not fit in memory for mirroring
+	large := strings.Repeat("large mirror", 100*1024)
+	hashLarge, _, _ := repo.Add(strings.NewReader(large))
+	repo.Commit("2nd commit")
+
+	err = repo.Push(true, func(total int64) {})
+	assert.Nil(t, err)
+
+	before, _ := repo.Statistics()
+
+	err = repo.Mirror("primary", "mirror")
+	assert.Nil(t, err)
+
+	after, _ := repo.Statistics()
+	assert.Equal(t, before.CacheSize, after.CacheSize, "Expected cache to be untouched")
+
+	primary, _ := ioutil.ReadDir(primaryDir)
+	mirror, _ := ioutil.ReadDir(mirrorDir)
+	assert.Equal(t, len(primary), len(mirror), "Expected all objects to be mirrored")
+
+	// Mirroring again is a no-op
+	assert.Nil(t, repo.Mirror("primary", "mirror"))
+
+	// Pull from mirror into new repository
+	path2, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path2)
+	repo2, _ := InitRepository(path2, InitOptionSetLeafSize(4096))
+	repo2.remoteAddFake("mirror", mirrorDir)
+
+	err = repo2.Pull(func(total int64) {})
+	assert.Nil(t, err)
+
+	r, err := repo2.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, content, string(output))
+
+	r, err = repo2.Get(hashLarge)
+	assert.Nil(t, err)
+	output, _ = ioutil.ReadAll(r)
+	assert.Equal(t, large, string(output))
+}
@@ -21,21 +21,64 @@ import (
 	"github.com/bmatsuo/lmdb-go/lmdb"
 	"github.com/s3git/s3git-go/internal/backend"
 	"github.com/s3git/s3git-go/internal/cas"
+	"github.com/s3git/s3git-go/internal/config"
 	"github.com/s3git/s3git-go/internal/core"
 	"github.com/s3git/s3git-go/internal/kv"
 	"io/ioutil"
 	"os"
+	"sync"
 )
 
+type pullOptions struct {
+	remote string
+	limits *config.LimitsObject
+}
+
+// Pull from the remote with the given name (instead of the first remote)
+func PullOptionSetRemote(remote string) func(optns *pullOptions) {
+	return func(optns *pullOptions) {
+		optns.remote = remote
+	}
+}
+
+// Limit the bandwidth and request rate for this pull (instead of the limits of the remote)
+func PullOptionSetLimits(bytesPerSecond int64, requestsPerSecond float64) func(optns *pullOptions) {
+	return func(optns *pullOptions) {
+		optns.limits = optns.limits.Override(&config.LimitsObject{BytesPerSecond: bytesPerSecond, RequestsPerSecond: requestsPerSecond})
+	}
+}
+
+// Number of prefixes to pull in parallel (defaults to 8)
+func PullOptionSetConcurrency(concurrency int) func(optns *pullOptions) {
+	return func(optns *pullOptions) {
+		optns.limits = optns.limits.Override(&config.LimitsObject{Concurrency: concurrency})
+	}
+}
+
+type PullOptions func(*pullOptions)
+
 // Pull updates for the repository
-func (repo Repository) Pull(progress func(maxTicks int64)) error {
+func (repo Repository) Pull(progress func(maxTicks int64), options ...PullOptions) error {
+
+	optns := &pullOptions{}
+	for _, op := range options {
+		op(optns)
+	}
 
-	return pull(progress)
+	return pull(progress, optns.remote, optns.limits)
 }
 
-func pull(progress func(maxTicks int64)) error {
+const pullPrefixRoutines = 8
 
-	client, err := backend.GetDefaultClient()
+func pull(progress func(maxTicks int64), remote string, limits *config.LimitsObject) error {
+
+	remoteObject, err := config.GetRemote(remote)
+	if err != nil {
+		return err
+	}
+	limits = remoteObject.Limits.Override(limits)
+
+	client, err := backend.GetClientWithLimits(remote, limits)
 	if err != nil {
 		return err
 	}
@@ -68,19 +111,43 @@ func pull(progress func(maxTicks int64)) error {
 
 	progress(int64(len(prefixesToFetch)))
 
-	// TODO: [perf] Speed up by running parallel in multiple go routines
-	for _, prefix := range prefixesToFetch {
+	var wg sync.WaitGroup
+	var msgs = make(chan string)
+	var results = make(chan error)
 
-		// Fetch Prefix object and all objects directly and indirectly referenced by it
-		err = fetchPrefix(prefix, client)
-		if err != nil {
-			return err
-		}
+	for i := 0; i < min(len(prefixesToFetch), limits.GetConcurrency(pullPrefixRoutines)); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			for prefix := range msgs {
+				// Fetch Prefix object and all objects directly and indirectly referenced by it
+				results <- fetchPrefix(prefix, client)
+			}
+		}()
+	}
 
+	go func() {
+		for _, prefix := range prefixesToFetch {
+			msgs <- prefix
+		}
+		close(msgs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Keep the first error (but wait for all prefixes to finish)
+	for e := range results {
+		if e != nil && err == nil {
+			err = e
+		}
 		progress(int64(len(prefixesToFetch)))
 	}
 
-	return nil
+	return err
 }
 
 // Fetch Prefix object and all objects directly and indirectly referenced by it
@@ -18,6 +18,7 @@ package s3git
 
 import (
 	"fmt"
+	"github.com/s3git/s3git-go/internal/config"
 	"github.com/s3git/s3git-go/internal/core"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -44,6 +45,39 @@ func TestPull(t *testing.T) {
 	assert.Equal(t, uint64(10), stats.Objects, "Number of objects is not correct")
 }
 
+func TestPullInParallel(t *testing.T) {
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+
+	repoFake, pathFake := setupRepo()
+	repoFake.remoteAddFake("fake", fakeDir)
+	defer teardownRepo(pathFake)
+
+	hashes := []string{}
+	for i := 0; i < 5; i++ {
+		hash, _, _ := repoFake.Add(strings.NewReader(fmt.Sprintf("hello s3git: parallel %d", i)))
+		hashes = append(hashes, hash)
+		repoFake.Commit(fmt.Sprintf("commit %d", i))
+	}
+	assert.Nil(t, repoFake.Push(true, func(total int64) {}))
+
+	repo, path := setupRepo()
+	repo.remoteAddFake("fake", fakeDir)
+	defer teardownRepo(path)
+
+	ticks := 0
+	err := repo.Pull(func(total int64) { ticks++ }, PullOptionSetConcurrency(3))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, ticks, "Expected progress for every prefix")
+
+	for i, hash := range hashes {
+		r, err := repo.Get(hash)
+		assert.Nil(t, err)
+		output, _ := ioutil.ReadAll(r)
+		assert.Equal(t, fmt.Sprintf("hello s3git: parallel %d", i), string(output))
+	}
+}
+
 func testCreateFakeRepo(t *testing.T, fakeDir string) {
 
 	repoFake, path := setupRepo()
@@ -62,6 +96,41 @@ func testCreateFakeRepo(t *testing.T, fakeDir string) {
 	assert.Nil(t, err)
 }
 
+func TestPullEncrypted(t *testing.T) {
+
+	fakeDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	key := strings.Repeat("5e", 32)
+
+	repoFake, pathFake := setupRepo()
+	config.SetEncryptionKey(key)
+	repoFake.remoteAddFake("fake", fakeDir)
+	defer teardownRepo(pathFake)
+
+	hash, _, _ := repoFake.Add(strings.NewReader("hello s3git: encrypted"))
+	repoFake.Commit("1st commit")
+	err := repoFake.Push(true, func(total int64) {})
+	assert.Nil(t, err)
+
+	// Objects are stored under their hash, but contents are encrypted
+	contents, err := ioutil.ReadFile(fakeDir + "/" + hash)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(contents), "hello s3git")
+
+	path, _ := ioutil.TempDir("", "s3git-test-")
+	repo, err := InitRepository(path, InitOptionSetEncryptionKey(key))
+	assert.Nil(t, err)
+	repo.remoteAddFake("fake", fakeDir)
+	defer teardownRepo(path)
+
+	err = repo.Pull(func(total int64) {})
+	assert.Nil(t, err)
+
+	r, err := repo.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello s3git: encrypted", string(output))
+}
+
 func TestBadRepo(t *testing.T) {
 
 	// TODO: [test] Add test case to detect bad/incomplete remotes (i.e. tree objects missing)
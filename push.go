@@ -22,6 +22,7 @@ import (
 
 	"github.com/s3git/s3git-go/internal/backend"
 	"github.com/s3git/s3git-go/internal/cas"
+	"github.com/s3git/s3git-go/internal/config"
 	"github.com/s3git/s3git-go/internal/core"
 	"github.com/s3git/s3git-go/internal/kv"
 
@@ -30,21 +31,66 @@ import (
 	"sync"
 )
 
+type pushOptions struct {
+	remote string
+	limits *config.LimitsObject
+}
+
+// Push to the remote with the given name (instead of the first remote)
+func PushOptionSetRemote(remote string) func(optns *pushOptions) {
+	return func(optns *pushOptions) {
+		optns.remote = remote
+	}
+}
+
+// Limit the bandwidth and request rate for this push (instead of the limits of the remote)
+func PushOptionSetLimits(bytesPerSecond int64, requestsPerSecond float64) func(optns *pushOptions) {
+	return func(optns *pushOptions) {
+		optns.limits = optns.limits.Override(&config.LimitsObject{BytesPerSecond: bytesPerSecond, RequestsPerSecond: requestsPerSecond})
+	}
+}
+
+// Number of blobs to push in parallel (defaults to 100)
+func PushOptionSetConcurrency(concurrency int) func(optns *pushOptions) {
+	return func(optns *pushOptions) {
+		optns.limits = optns.limits.Override(&config.LimitsObject{Concurrency: concurrency})
+	}
+}
+
+type PushOptions func(*pushOptions)
+
 // Perform a push to the back end for the repository
-func (repo Repository) Push(hydrated bool, progress func(maxTicks int64)) error {
+func (repo Repository) Push(hydrated bool, progress func(maxTicks int64), options ...PushOptions) error {
+
+	optns := &pushOptions{}
+	for _, op := range options {
+		op(optns)
+	}
 
 	list, err := kv.ListLevel1Prefixes()
 	if err != nil {
 		return err
 	}
 
-	return push(list, hydrated, progress)
+	return push(list, hydrated, progress, optns.remote, optns.limits)
 }
 
+const pushBlobRoutines = 100
+
+// Blobs of at least this size are uploaded in parts (in parallel per blob)
+const multipartMinimumSize = 16 * 1024 * 1024
+const pushPartRoutines = 8
+
 // Push any new commit objects including all added objects to the back end store
-func push(prefixChan <-chan []byte, hydrated bool, progress func(maxTicks int64)) error {
+func push(prefixChan <-chan []byte, hydrated bool, progress func(maxTicks int64), remote string, limits *config.LimitsObject) error {
+
+	remoteObject, err := config.GetRemote(remote)
+	if err != nil {
+		return err
+	}
+	limits = remoteObject.Limits.Override(limits)
 
-	client, err := backend.GetDefaultClient()
+	client, err := backend.GetClientWithLimits(remote, limits)
 	if err != nil {
 		return err
 	}
@@ -71,7 +117,7 @@ func push(prefixChan <-chan []byte, hydrated bool, progress func(maxTicks int64)
 	}
 
 	if len(prefixesToPush) == 0 {
-		return nil
+		return publishIndex(remote, client, prefixesInBackend)
 	}
 
 	progress(int64(len(prefixesToPush)))
@@ -99,7 +145,7 @@ func push(prefixChan <-chan []byte, hydrated bool, progress func(maxTicks int64)
 			}
 
 			// first push all added blobs in this commit ...
-			err = pushBlobRange(to.S3gitAdded, nil, hydrated, client)
+			err = pushBlobRange(to.S3gitAdded, nil, hydrated, client, limits.GetConcurrency(pushBlobRoutines))
 			if err != nil {
 				return err
 			}
@@ -133,11 +179,31 @@ func push(prefixChan <-chan []byte, hydrated bool, progress func(maxTicks int64)
 		if err != nil {
 			return err
 		}
+		prefixesInBackend[prefix] = true
 
 		progress(int64(len(prefixesToPush)))
 	}
 
-	return nil
+	return publishIndex(remote, client, prefixesInBackend)
+}
+
+// Publish the index of prefix objects when the remote is (also) served by a static web server
+func publishIndex(remote string, client backend.Backend, prefixes map[string]bool) error {
+
+	r, err := config.GetRemote(remote)
+	if err != nil {
+		return err
+	}
+	if !r.Publish {
+		return nil
+	}
+
+	list := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		list = append(list, prefix)
+	}
+
+	return backend.PublishIndex(client, list)
 }
 
 func pushSnapshotWithChildren(hash string, client backend.Backend) error {
@@ -177,13 +243,12 @@ func pushBlob(hash string, size *uint64, client backend.Backend) (newlyUploaded
 		}
 	}
 
-	// TODO: [perf] for back ends storing whole files: consider multipart upload?
 	cr := cas.MakeReader(hash)
 	if cr == nil {
 		panic(errors.New("Failed to create cas reader"))
 	}
 
-	err = client.UploadWithReader(hash, cr)
+	err = uploadBlob(hash, cr, client)
 	if err != nil {
 		return false, err
 	}
@@ -197,6 +262,26 @@ func pushBlob(hash string, size *uint64, client backend.Backend) (newlyUploaded
 	return true, nil
 }
 
+// Upload a blob as a whole, large blobs are uploaded in parts that map to leaves when the back end supports it
+func uploadBlob(hash string, cr *cas.Reader, client backend.Backend) error {
+
+	uploader, ok := backend.GetMultipartUploader(client)
+	if !ok {
+		return client.UploadWithReader(hash, cr)
+	}
+
+	size, err := cr.Size()
+	if err != nil {
+		return err
+	}
+	if size < multipartMinimumSize {
+		return client.UploadWithReader(hash, cr)
+	}
+
+	// An upload that fails is kept (unless it cannot be resumed), so that pushing again resumes it
+	return uploader.UploadMultipart(hash, cr, size, int64(config.Config.LeafSize), pushPartRoutines, nil)
+}
+
 // Push a blob to the back end store in deduplicated format
 func PushBlobDeduped(hash string, size *uint64, client backend.Backend) (newlyUploaded bool, err error) {
 
@@ -225,9 +310,8 @@ func PushBlobDeduped(hash string, size *uint64, client backend.Backend) (newlyUp
 		}
 	}
 
-	// Finally upload root hash
-	b := bytes.NewBuffer(leafHashes)
-	err = client.UploadWithReader(hash, b)
+	// Finally upload root hash (with a seekable reader so that the upload can be retried)
+	err = client.UploadWithReader(hash, bytes.NewReader(leafHashes))
 	if err != nil {
 		return false, err
 	}
@@ -245,13 +329,13 @@ func PushBlobDeduped(hash string, size *uint64, client backend.Backend) (newlyUp
 //
 // See https://github.com/adonovan/gopl.io/blob/master/ch8/thumbnail/thumbnail_test.go
 //
-func pushBlobRange(hashes []string, size *uint64, hydrated bool, client backend.Backend) error {
+func pushBlobRange(hashes []string, size *uint64, hydrated bool, client backend.Backend, concurrency int) error {
 
 	var wg sync.WaitGroup
 	var msgs = make(chan string)
 	var results = make(chan error)
 
-	for i := 0; i < min(len(hashes), 100); i++ {
+	for i := 0; i < min(len(hashes), concurrency); i++ {
 		wg.Add(1)
 
 		go func() {
@@ -285,9 +369,10 @@ func pushBlobRange(hashes []string, size *uint64, hydrated bool, client backend.
 		close(results)
 	}()
 
+	// Keep the first error (but wait for all uploads to finish)
 	var err error
 	for e := range results {
-		if e != nil {
+		if e != nil && err == nil {
 			err = e
 		}
 	}
@@ -295,16 +380,50 @@ func pushBlobRange(hashes []string, size *uint64, hydrated bool, client backend.
 	return err
 }
 
+// Check whether all leaves (except for the last node) are of size LeafSize, otherwise
+// the leaf boundaries cannot be computed for a blob that is stored in hydrated format
 func checkIfLeavesAreEqualSize(hash string) bool {
-	// TODO: Implement: iterate over all leaves, check whether (except for last node) all sizes are equal
+
+	hx, err := hex.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+
+	leafHashes, _, err := kv.GetLevel1(hx)
+	if err != nil || len(leafHashes) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(leafHashes); i += cas.KeySize {
+		size, found, err := kv.GetLevel0Size(hex.EncodeToString(leafHashes[i : i+cas.KeySize]))
+		if err != nil {
+			return false
+		}
+		if !found {
+			// Size is unknown for leaves that are no longer local, so inspect the leaves instead
+			equal, err := cas.HasLeavesOfLeafSize(hash)
+			return err == nil && equal
+		}
+
+		lastNode := i == len(leafHashes)-cas.KeySize
+		if !lastNode && size != config.Config.LeafSize || lastNode && size > config.Config.LeafSize {
+			return false
+		}
+	}
+
 	return true
 }
 
 // List prefixes at back end store, doing 16 lists in parallel
 func listPrefixes(client backend.Backend) (map[string]bool, error) {
 
+	type listResult struct {
+		keys []string
+		err  error
+	}
+
 	var wg sync.WaitGroup
-	var results = make(chan []string)
+	var results = make(chan listResult)
 
 	for i := 0x0; i <= 0xf; i++ {
 		wg.Add(1)
@@ -313,15 +432,12 @@ func listPrefixes(client backend.Backend) (map[string]bool, error) {
 			defer wg.Done()
 			result := make([]string, 0, 1000)
 
-			client.List(fmt.Sprintf("%s%x", core.Prefix(), i), func(key string) {
+			keys, errs := client.List(fmt.Sprintf("%s%x", core.Prefix(), i))
+			for key := range keys {
 				result = append(result, key)
+			}
 
-				// TODO: WE NEED TO wg.Done() HERE WHEN LAST KEY HAS BEEN RECEIVED
-				//       -- SEE /Users/frankw/golang/src/github.com/fwessels/listperf/listperf.go
-				// IMPORTANT: WE WILL BE MISSING OBJECTS HERE
-			})
-
-			results <- result
+			results <- listResult{keys: result, err: <-errs}
 		}(i)
 	}
 
@@ -330,12 +446,19 @@ func listPrefixes(client backend.Backend) (map[string]bool, error) {
 		close(results)
 	}()
 
+	var err error
 	prefixHash := make(map[string]bool)
 	for result := range results {
-		for _, r := range result {
+		if result.err != nil {
+			err = result.err
+		}
+		for _, r := range result.keys {
 			prefixHash[r] = true
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	return prefixHash, nil
 }
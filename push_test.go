@@ -17,9 +17,14 @@
 package s3git
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/s3git/s3git-go/internal/backend"
 	"github.com/s3git/s3git-go/internal/core"
-	_ "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"io"
+	"math/rand"
 	"strings"
 	"testing"
 )
@@ -38,3 +43,62 @@ func TestPush(t *testing.T) {
 
 	repo.Push(false, func(total int64) {})
 }
+
+func TestCheckIfLeavesAreEqualSize(t *testing.T) {
+	path, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path)
+
+	repo, _ := InitRepository(path, InitOptionSetLeafSize(1024))
+
+	hash, _, _ := repo.Add(strings.NewReader(strings.Repeat("s3git", 1000)))
+	assert.True(t, checkIfLeavesAreEqualSize(hash), "Expected leaves of equal size")
+}
+
+func TestCheckIfLeavesAreEqualSizeRollingHash(t *testing.T) {
+	path, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path)
+
+	repo, _ := InitRepository(path, InitOptionSetLeafSize(64*1024), InitOptionSetRollingHash(10, 1024))
+
+	input := make([]byte, 256*1024)
+	rand.New(rand.NewSource(42)).Read(input)
+
+	hash, _, _ := repo.Add(bytes.NewReader(input))
+	assert.False(t, checkIfLeavesAreEqualSize(hash), "Expected leaves of different sizes")
+}
+
+// Back end that fails the first upload of every object
+type failOnceBackend struct {
+	backend.Backend
+	attempts map[string]int
+	uploaded map[string][]byte
+}
+
+func (f *failOnceBackend) UploadWithReader(hash string, r io.Reader) error {
+	data, _ := ioutil.ReadAll(r)
+	f.attempts[hash]++
+	if f.attempts[hash] == 1 {
+		return io.ErrUnexpectedEOF
+	}
+	f.uploaded[hash] = data
+	return nil
+}
+
+func TestPushBlobDedupedRetries(t *testing.T) {
+	path, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path)
+
+	repo, _ := InitRepository(path, InitOptionSetLeafSize(1024))
+	hash, _, _ := repo.Add(strings.NewReader(strings.Repeat("s3git", 1000)))
+
+	failOnce := &failOnceBackend{attempts: make(map[string]int), uploaded: make(map[string][]byte)}
+	client := backend.MakeRetrying(failOnce, backend.MakeRetryPolicy(nil), func(err error) bool { return err == io.ErrUnexpectedEOF })
+
+	_, err := PushBlobDeduped(hash, nil, client)
+	assert.Nil(t, err)
+
+	// Root object with the leaf hashes is retried as well as the leaves
+	assert.Equal(t, 2, failOnce.attempts[hash])
+	assert.Equal(t, 6, len(failOnce.uploaded))
+	assert.Equal(t, 5*64, len(failOnce.uploaded[hash]))
+}
@@ -17,21 +17,118 @@
 package s3git
 
 import (
+	"errors"
+	"fmt"
+	"github.com/s3git/s3git-go/internal/backend"
 	"github.com/s3git/s3git-go/internal/config"
+	"strings"
+	"time"
 )
 
 type Remote struct {
 	Name     string
+	Type     string
 	Resource string
+	Endpoint string
 }
 
 type remoteOptions struct {
-	endpoint string
+	endpoint    *string
+	accessKey   *string
+	secretKey   *string
+	region      *string
+	hydrate     *bool
+	publish     *bool
+	sasToken    *string
+	retry       *config.RetryObject
+	rates       *config.LimitsObject
+	concurrency *int
+	maxMetadata *int64
+	provider    *string
+	source      string
 }
 
 func RemoteOptionSetEndpoint(endpoint string) func(optns *remoteOptions) {
 	return func(optns *remoteOptions) {
-		optns.endpoint = endpoint
+		optns.endpoint = &endpoint
+	}
+}
+
+func RemoteOptionSetAccessKey(accessKey string) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.accessKey = &accessKey
+	}
+}
+
+func RemoteOptionSetSecretKey(secretKey string) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.secretKey = &secretKey
+	}
+}
+
+func RemoteOptionSetRegion(region string) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.region = &region
+	}
+}
+
+func RemoteOptionSetHydrate(hydrate bool) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.hydrate = &hydrate
+	}
+}
+
+// Get the credentials from a provider ("static", "env", "shared", "process" or "keyring"). The source
+// is the profile for "shared" and the command for "process". For "keyring" the keys (when given) are
+// stored in the keyring, which is unlocked with S3GIT_KEYRING_PASSPHRASE. Keys are only stored in the
+// config for "static". New remotes default to the keyring when keys are given, otherwise to "env".
+func RemoteOptionSetCredentialProvider(provider, source string) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.provider = &provider
+		optns.source = source
+	}
+}
+
+// Authenticate to Azure with a shared access signature (instead of the account key)
+func RemoteOptionSetSasToken(sasToken string) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.sasToken = &sasToken
+	}
+}
+
+// Retry failed operations up to a maximum number of attempts (1 disables retrying), with an exponential
+// backoff starting at backoff up to maxBackoff, of which the jitter fraction (between 0 and 1) is randomized
+func RemoteOptionSetRetry(maxAttempts int, backoff, maxBackoff time.Duration, jitter float64) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.retry = &config.RetryObject{MaxAttempts: maxAttempts, BackoffMillis: int(backoff / time.Millisecond), MaxBackoffMillis: int(maxBackoff / time.Millisecond), Jitter: jitter}
+	}
+}
+
+// Limit the bandwidth (for uploads and downloads combined) and request rate for the remote (0 is unlimited)
+func RemoteOptionSetLimits(bytesPerSecond int64, requestsPerSecond float64) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.rates = &config.LimitsObject{BytesPerSecond: bytesPerSecond, RequestsPerSecond: requestsPerSecond}
+	}
+}
+
+// Number of objects to transfer in parallel for the remote (0 for the default)
+func RemoteOptionSetConcurrency(concurrency int) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.concurrency = &concurrency
+	}
+}
+
+// Maximum size of (metadata) objects that are stored on the metadata tier of a tiered remote
+func RemoteOptionSetMaxMetadataSize(size int64) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.maxMetadata = &size
+	}
+}
+
+// Publish an index on push so that the remote can be served by a static web server (as an http(s):// remote)
+func RemoteOptionSetPublish(publish bool) func(optns *remoteOptions) {
+	return func(optns *remoteOptions) {
+		optns.publish = &publish
 	}
 }
 
@@ -44,7 +141,22 @@ func (repo Repository) RemoteAdd(name, resource, accessKey, secretKey string, op
 		op(optns)
 	}
 
-	remote, err := config.CreateRemote(name, resource, accessKey, secretKey, optns.endpoint)
+	endpoint := ""
+	if optns.endpoint != nil {
+		endpoint = *optns.endpoint
+	}
+
+	remote, err := config.CreateRemote(name, resource, accessKey, secretKey, endpoint)
+	if err != nil {
+		return err
+	}
+	err = optns.apply(remote)
+	if err != nil {
+		return err
+	}
+	config.DefaultCredentialProvider(remote)
+
+	err = pingAndStoreCredentials(remote)
 	if err != nil {
 		return err
 	}
@@ -52,18 +164,284 @@ func (repo Repository) RemoteAdd(name, resource, accessKey, secretKey string, op
 	return config.AddRemote(remote)
 }
 
+// Remove a remote
+func (repo Repository) RemoteRemove(name string) error {
+
+	return config.RemoveRemote(name)
+}
+
+// Rename a remote
+func (repo Repository) RemoteRename(name, newName string) error {
+
+	return config.RenameRemote(name, newName)
+}
+
+// Update the credentials, endpoint, region or hydrate flag of a remote (checked by pinging the remote)
+func (repo Repository) RemoteUpdate(name string, options ...RemoteOptions) error {
+
+	optns := &remoteOptions{}
+	for _, op := range options {
+		op(optns)
+	}
+
+	if name == "" {
+		return errors.New("No remote name specified")
+	}
+	remote, err := config.GetRemote(name)
+	if err != nil {
+		return err
+	}
+	err = optns.apply(remote)
+	if err != nil {
+		return err
+	}
+
+	err = pingAndStoreCredentials(remote)
+	if err != nil {
+		return err
+	}
+
+	return config.UpdateRemote(remote)
+}
+
+// Ping a remote and, when using a credential provider, move any keys out of the remote (into the keyring)
+func pingAndStoreCredentials(remote *config.RemoteObject) error {
+
+	if len(remote.Shards) > 0 {
+		for i := range remote.Shards {
+			err := pingAndStoreCredentials(&remote.Shards[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	accessKey, secretKey := remote.S3AccessKey, remote.S3SecretKey
+	if remote.Type == config.REMOTE_DYNAMODB {
+		accessKey, secretKey = remote.DynamoDbAccessKey, remote.DynamoDbSecretKey
+	}
+
+	// Ping with keys that are about to be stored in the keyring
+	ping := *remote
+	if remote.CredentialProvider == config.CREDENTIALS_KEYRING && (accessKey != "" || secretKey != "") {
+		ping.CredentialProvider = config.CREDENTIALS_STATIC
+	}
+	err := backend.PingRemote(ping)
+	if err != nil {
+		return err
+	}
+
+	return config.StoreCredentials(remote)
+}
+
+// Apply the options that are set to a remote
+func (optns *remoteOptions) apply(remote *config.RemoteObject) error {
+
+	if len(remote.Shards) > 0 {
+		// Credentials and endpoints are for the shards (of a sharded or erasure coded remote) or tiers
+		for i := range remote.Shards {
+			err := optns.applyCredentials(&remote.Shards[i])
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err := optns.applyCredentials(remote)
+		if err != nil {
+			return err
+		}
+	}
+
+	if optns.hydrate != nil {
+		remote.Hydrate = *optns.hydrate
+	}
+	if optns.publish != nil {
+		remote.Publish = *optns.publish
+	}
+	if optns.retry != nil {
+		remote.Retry = optns.retry
+	}
+	if optns.maxMetadata != nil {
+		remote.TieredMaxMetadataSize = *optns.maxMetadata
+	}
+	if optns.rates != nil || optns.concurrency != nil {
+		limits := config.LimitsObject{}
+		if remote.Limits != nil {
+			limits = *remote.Limits
+		}
+		if optns.rates != nil {
+			limits.BytesPerSecond, limits.RequestsPerSecond = optns.rates.BytesPerSecond, optns.rates.RequestsPerSecond
+		}
+		if optns.concurrency != nil {
+			limits.Concurrency = *optns.concurrency
+		}
+		remote.Limits = &limits
+	}
+
+	return nil
+}
+
+// Apply the credential provider, credentials, endpoint and region that are set to a remote
+func (optns *remoteOptions) applyCredentials(remote *config.RemoteObject) error {
+
+	if optns.provider != nil {
+		if !config.IsCredentialProvider(*optns.provider) {
+			return errors.New(fmt.Sprintf("Unknown credential provider: %s", *optns.provider))
+		}
+		remote.CredentialProvider, remote.CredentialSource = *optns.provider, optns.source
+	}
+
+	switch remote.Type {
+	case config.REMOTE_AZURE:
+		if optns.accessKey != nil {
+			remote.AzureAccount = *optns.accessKey
+		}
+		if optns.secretKey != nil {
+			remote.AzureAccountKey = *optns.secretKey
+		}
+		if optns.sasToken != nil {
+			remote.AzureSasToken = *optns.sasToken
+		}
+		if optns.endpoint != nil {
+			remote.AzureEndpoint = *optns.endpoint
+		}
+	case config.REMOTE_DYNAMODB:
+		if optns.accessKey != nil {
+			remote.DynamoDbAccessKey = *optns.accessKey
+		}
+		if optns.secretKey != nil {
+			remote.DynamoDbSecretKey = *optns.secretKey
+		}
+		if optns.region != nil {
+			remote.DynamoDbRegion = *optns.region
+		}
+	default:
+		if optns.accessKey != nil {
+			remote.S3AccessKey = *optns.accessKey
+		}
+		if optns.secretKey != nil {
+			remote.S3SecretKey = *optns.secretKey
+		}
+		if optns.endpoint != nil {
+			remote.S3Endpoint = *optns.endpoint
+		}
+		if optns.region != nil {
+			remote.S3Region = *optns.region
+		}
+	}
+
+	return nil
+}
+
 func (repo Repository) remoteAddFake(name, directory string) error {
 
 	return config.AddFakeRemote(name, directory)
 }
 
+// Show the remotes in the order in which they are tried for reads
 func (repo Repository) RemotesShow() ([]Remote, error) {
 
 	remotes := []Remote{}
 
-	for _, r := range config.Config.Remotes {
-		remotes = append(remotes, Remote{Name: r.Name, Resource: r.S3Bucket})
+	for _, r := range config.GetRemotesInOrder() {
+		remotes = append(remotes, showRemote(r))
 	}
 
 	return remotes, nil
 }
+
+func showRemote(r config.RemoteObject) Remote {
+
+	remote := Remote{Name: r.Name, Type: r.Type}
+
+	switch r.Type {
+	case config.REMOTE_SHARDED, config.REMOTE_ERASURE, config.REMOTE_TIERED:
+		resources, endpoints := []string{}, []string{}
+		for _, shard := range r.Shards {
+			shardRemote := showRemote(shard)
+			resources, endpoints = append(resources, shardRemote.Resource), append(endpoints, shardRemote.Endpoint)
+		}
+		remote.Resource, remote.Endpoint = strings.Join(resources, ","), strings.Join(endpoints, ",")
+	case config.REMOTE_FAKE:
+		remote.Resource, remote.Endpoint = r.FakeDirectory, r.FakeDirectory
+	case config.REMOTE_AZURE:
+		remote.Resource, remote.Endpoint = r.AzureContainer, r.AzureEndpoint
+		if remote.Endpoint == "" {
+			remote.Endpoint = fmt.Sprintf("%s.blob.core.windows.net", r.AzureAccount)
+		}
+	case config.REMOTE_ARCHIVE:
+		remote.Resource, remote.Endpoint = r.ArchivePath, "archive://" + r.ArchivePath
+	case config.REMOTE_HTTP:
+		remote.Resource, remote.Endpoint = r.HttpUrl, r.HttpUrl
+	case config.REMOTE_FILE:
+		remote.Resource, remote.Endpoint = r.FileDirectory, "file://"+r.FileDirectory
+	case config.REMOTE_ACD:
+		remote.Endpoint = "drive.amazonaws.com"
+	case config.REMOTE_DYNAMODB:
+		remote.Resource, remote.Endpoint = r.DynamoDbTable, fmt.Sprintf("dynamodb.%s.amazonaws.com", r.DynamoDbRegion)
+	default: // config.REMOTE_S3
+		remote.Resource, remote.Endpoint = r.S3Bucket, r.S3Endpoint
+		if remote.Endpoint == "" {
+			remote.Endpoint = fmt.Sprintf("s3.%s.amazonaws.com", r.S3Region)
+		}
+	}
+
+	return remote
+}
+
+// Set the order in which remotes are tried for on demand reads
+func (repo Repository) RemotesSetOrder(names ...string) error {
+
+	return config.SetRemoteOrder(names)
+}
+
+// Add an alternate: a read-only remote that is fallen back to (after all remotes and
+// earlier alternates) for objects that are missing on the remotes
+func (repo Repository) AlternateAdd(name, resource, accessKey, secretKey string, options ...RemoteOptions) error {
+
+	optns := &remoteOptions{}
+	for _, op := range options {
+		op(optns)
+	}
+
+	endpoint := ""
+	if optns.endpoint != nil {
+		endpoint = *optns.endpoint
+	}
+
+	alternate, err := config.CreateRemote(name, resource, accessKey, secretKey, endpoint)
+	if err != nil {
+		return err
+	}
+	err = optns.apply(alternate)
+	if err != nil {
+		return err
+	}
+	config.DefaultCredentialProvider(alternate)
+
+	err = pingAndStoreCredentials(alternate)
+	if err != nil {
+		return err
+	}
+
+	return config.AddAlternate(alternate)
+}
+
+// Remove an alternate
+func (repo Repository) AlternateRemove(name string) error {
+
+	return config.RemoveAlternate(name)
+}
+
+// Show the alternates in the order in which they are tried for reads
+func (repo Repository) AlternatesShow() ([]Remote, error) {
+
+	alternates := []Remote{}
+
+	for _, a := range config.Config.Alternates {
+		alternates = append(alternates, showRemote(a))
+	}
+
+	return alternates, nil
+}
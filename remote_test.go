@@ -0,0 +1,449 @@
+/*
+ * Copyright 2016 Frank Wessels <fwessels@xs4all.nl>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package s3git
+
+import (
+	"fmt"
+	"github.com/s3git/s3git-go/internal/backend/file"
+	"github.com/s3git/s3git-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPushAndPullNamedRemote(t *testing.T) {
+
+	primaryDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(primaryDir)
+	mirrorDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(mirrorDir)
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+	assert.Nil(t, repo.remoteAddFake("primary", primaryDir))
+	assert.Nil(t, repo.remoteAddFake("mirror", mirrorDir))
+
+	hash, _, _ := repo.Add(strings.NewReader("hello s3git: mirror"))
+	repo.Commit("1st commit")
+
+	err := repo.Push(true, func(total int64) {}, PushOptionSetRemote("mirror"))
+	assert.Nil(t, err)
+
+	primary, _ := ioutil.ReadDir(primaryDir)
+	assert.Equal(t, 0, len(primary), "Expected nothing pushed to primary")
+	mirror, _ := ioutil.ReadDir(mirrorDir)
+	assert.NotEqual(t, 0, len(mirror), "Expected objects pushed to mirror")
+
+	// Pull from mirror while primary (which is tried first for reads) is empty
+	repo2, path2 := setupRepo()
+	defer teardownRepo(path2)
+	repo2.remoteAddFake("primary", primaryDir)
+	repo2.remoteAddFake("mirror", mirrorDir)
+
+	err = repo2.Pull(func(total int64) {}, PullOptionSetRemote("mirror"))
+	assert.Nil(t, err)
+
+	r, err := repo2.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello s3git: mirror", string(output))
+}
+
+func TestRemotesShow(t *testing.T) {
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+
+	repo.remoteAddFake("primary", path + "/primary")
+	repo.remoteAddFake("mirror", path + "/mirror")
+	assert.NotNil(t, repo.remoteAddFake("mirror", path + "/other"), "Expected error for duplicate name")
+
+	remotes, err := repo.RemotesShow()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(remotes))
+	assert.Equal(t, "primary", remotes[0].Name)
+	assert.Equal(t, config.REMOTE_FAKE, remotes[0].Type)
+	assert.Equal(t, path + "/primary", remotes[0].Endpoint)
+
+	err = repo.RemotesSetOrder("mirror")
+	assert.Nil(t, err)
+	remotes, _ = repo.RemotesShow()
+	assert.Equal(t, "mirror", remotes[0].Name)
+	assert.Equal(t, "primary", remotes[1].Name)
+
+	// Default remote (eg. for push) is unaffected by the order for reads
+	remote, _ := config.GetRemote("")
+	assert.Equal(t, "primary", remote.Name)
+
+	assert.NotNil(t, repo.RemotesSetOrder("unknown"), "Expected error for unknown remote")
+}
+
+func TestRemoteRemoveAndRename(t *testing.T) {
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+
+	repo.remoteAddFake("primary", path)
+	repo.remoteAddFake("mirror", path)
+	repo.RemotesSetOrder("mirror", "primary")
+
+	assert.NotNil(t, repo.RemoteRename("mirror", "primary"), "Expected error for existing name")
+	assert.Nil(t, repo.RemoteRename("mirror", "minio"))
+	assert.Nil(t, repo.RemoteRemove("primary"))
+	assert.NotNil(t, repo.RemoteRemove("primary"), "Expected error for removed remote")
+
+	remotes, _ := repo.RemotesShow()
+	assert.Equal(t, 1, len(remotes))
+	assert.Equal(t, "minio", remotes[0].Name)
+	assert.Equal(t, []string{"minio"}, config.Config.RemoteOrder)
+}
+
+func TestRemoteUpdate(t *testing.T) {
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+
+	repo.remoteAddFake("primary", path)
+	repo.remoteAddFake("missing", path + "/missing")
+
+	assert.Nil(t, repo.RemoteUpdate("primary", RemoteOptionSetHydrate(true)))
+	remote, _ := config.GetRemote("primary")
+	assert.True(t, remote.Hydrate)
+
+	// Update is refused when remote cannot be pinged
+	assert.NotNil(t, repo.RemoteUpdate("missing", RemoteOptionSetHydrate(true)))
+	remote, _ = config.GetRemote("missing")
+	assert.False(t, remote.Hydrate)
+
+	assert.NotNil(t, repo.RemoteUpdate("unknown"), "Expected error for unknown remote")
+}
+
+func TestPushAndPullFileRemote(t *testing.T) {
+
+	fileDir, _ := ioutil.TempDir("", "s3git-file-backend-")
+	defer os.RemoveAll(fileDir)
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+	assert.Nil(t, repo.RemoteAdd("usb", "file://" + fileDir, "", ""))
+	assert.NotNil(t, repo.RemoteAdd("missing", "file://" + fileDir + "/missing", "", ""), "Expected error for missing directory")
+
+	hash, _, _ := repo.Add(strings.NewReader("hello s3git: file"))
+	repo.Commit("1st commit")
+
+	err := repo.Push(true, func(total int64) {})
+	assert.Nil(t, err)
+
+	_, err = os.Stat(fileDir + "/" + hash[0:2] + "/" + hash[2:4] + "/" + hash)
+	assert.Nil(t, err, "Expected blob in sharded directory")
+
+	repo2, path2 := setupRepo()
+	defer teardownRepo(path2)
+	assert.Nil(t, repo2.RemoteAdd("usb", "file://" + fileDir, "", ""))
+
+	err = repo2.Pull(func(total int64) {})
+	assert.Nil(t, err)
+
+	r, err := repo2.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello s3git: file", string(output))
+}
+
+func TestPushAndPullWithLimits(t *testing.T) {
+
+	fileDir, _ := ioutil.TempDir("", "s3git-file-backend-")
+	defer os.RemoveAll(fileDir)
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+	assert.Nil(t, repo.RemoteAdd("usb", "file://" + fileDir, "", "", RemoteOptionSetLimits(1024*1024, 0), RemoteOptionSetConcurrency(4)))
+
+	for i := 0; i < 10; i++ {
+		repo.Add(strings.NewReader(fmt.Sprintf("hello s3git: limits %d", i)))
+	}
+	hash, _, _ := repo.Add(strings.NewReader("hello s3git: limits"))
+	repo.Commit("1st commit")
+
+	err := repo.Push(false, func(total int64) {}, PushOptionSetLimits(0, 1000), PushOptionSetConcurrency(2))
+	assert.Nil(t, err)
+
+	remote, _ := config.GetRemote("usb")
+	assert.Equal(t, config.LimitsObject{BytesPerSecond: 1024*1024, Concurrency: 4}, *remote.Limits)
+
+	repo2, path2 := setupRepo()
+	defer teardownRepo(path2)
+	assert.Nil(t, repo2.RemoteAdd("usb", "file://" + fileDir, "", ""))
+
+	err = repo2.Pull(func(total int64) {}, PullOptionSetLimits(1024*1024, 1000))
+	assert.Nil(t, err)
+
+	r, err := repo2.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello s3git: limits", string(output))
+}
+
+func TestCloneFromHttpRemote(t *testing.T) {
+
+	publishDir, _ := ioutil.TempDir("", "s3git-fake-backend-")
+	defer os.RemoveAll(publishDir)
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+	repo.remoteAddFake("primary", publishDir)
+	assert.Nil(t, repo.RemoteUpdate("primary", RemoteOptionSetPublish(true)))
+
+	hash, _, _ := repo.Add(strings.NewReader("hello s3git: http"))
+	repo.Commit("1st commit")
+
+	err := repo.Push(true, func(total int64) {})
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(publishDir)))
+	defer server.Close()
+
+	path2, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path2)
+
+	repo2, err := Clone(server.URL, path2)
+	assert.Nil(t, err)
+
+	r, err := repo2.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello s3git: http", string(output))
+
+	// Publish a second commit and pull it
+	repo, _ = OpenRepository(path)
+	hash2, _, _ := repo.Add(strings.NewReader("hello s3git: http again"))
+	repo.Commit("2nd commit")
+	assert.Nil(t, repo.Push(true, func(total int64) {}))
+
+	repo2, _ = OpenRepository(path2)
+	err = repo2.Pull(func(total int64) {})
+	assert.Nil(t, err)
+
+	r, err = repo2.Get(hash2)
+	assert.Nil(t, err)
+	output, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "hello s3git: http again", string(output))
+
+	// Remote is read-only
+	repo2.Add(strings.NewReader("not pushed"))
+	repo2.Commit("3rd commit")
+	assert.NotNil(t, repo2.Push(true, func(total int64) {}))
+}
+
+// Push a commit with blobs to a new remote for a resource and clone it into a new repository,
+// checking that all blobs can be read. Remote specific checks can be done before cloning
+func pushAndClone(t *testing.T, resource string, beforeClone func(commit string, hashes []string), options ...RemoteOptions) (*Repository, string) {
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+	assert.Nil(t, repo.RemoteAdd("remote", resource, "", "", options...))
+
+	hashes := []string{}
+	for i := 0; i < 20; i++ {
+		hash, _, _ := repo.Add(strings.NewReader(fmt.Sprintf("hello s3git: %s %d", resource, i)))
+		hashes = append(hashes, hash)
+	}
+	commit, _, _ := repo.Commit("1st commit")
+
+	err := repo.Push(false, func(total int64) {})
+	assert.Nil(t, err)
+
+	if beforeClone != nil {
+		beforeClone(commit, hashes)
+	}
+
+	path2, _ := ioutil.TempDir("", "s3git-test-")
+
+	repo2, err := Clone(resource, path2)
+	if !assert.Nil(t, err) {
+		return repo2, path2
+	}
+
+	for i, hash := range hashes {
+		r, err := repo2.Get(hash)
+		assert.Nil(t, err)
+		output, _ := ioutil.ReadAll(r)
+		assert.Equal(t, fmt.Sprintf("hello s3git: %s %d", resource, i), string(output))
+	}
+
+	return repo2, path2
+}
+
+func TestPushToArchiveAndClone(t *testing.T) {
+
+	archiveDir, _ := ioutil.TempDir("", "s3git-archive-")
+	defer os.RemoveAll(archiveDir)
+
+	repo2, path2 := pushAndClone(t, "archive://" + archiveDir + "/repo.db", nil)
+	defer teardownRepo(path2)
+
+	// Archive is opened read-only for the clone
+	repo2.Add(strings.NewReader("not pushed"))
+	repo2.Commit("2nd commit")
+	assert.NotNil(t, repo2.Push(true, func(total int64) {}))
+}
+
+func TestPushAndCloneShardedRemote(t *testing.T) {
+
+	dirs := []string{}
+	for i := 0; i < 3; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-sharded-")
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, "file://" + dir)
+	}
+
+	repo2, path2 := pushAndClone(t, "sharded://" + strings.Join(dirs, ","), func(commit string, hashes []string) {
+
+		// Objects are spread over all shards
+		for _, dir := range dirs {
+			files, _ := ioutil.ReadDir(strings.TrimPrefix(dir, "file://"))
+			assert.NotEqual(t, 0, len(files))
+		}
+	})
+	defer teardownRepo(path2)
+
+	remotes, _ := repo2.RemotesShow()
+	assert.Equal(t, strings.Join(dirs, ","), remotes[0].Endpoint)
+	assert.NotNil(t, repo2.RemoteAdd("single", "sharded://" + dirs[0], "", ""), "Expected error for a single shard")
+}
+
+func TestCloneFromErasureCodedRemoteWithMissingBackEnd(t *testing.T) {
+
+	dirs := []string{}
+	for i := 0; i < 3; i++ {
+		dir, _ := ioutil.TempDir("", "s3git-erasure-")
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, "file://" + dir)
+	}
+
+	repo2, path2 := pushAndClone(t, "erasure://2/" + strings.Join(dirs, ","), func(commit string, hashes []string) {
+
+		// Lose one of the back ends
+		os.RemoveAll(strings.TrimPrefix(dirs[0], "file://"))
+	})
+	defer teardownRepo(path2)
+
+	assert.NotNil(t, repo2.RemoteAdd("noparity", "erasure://3/" + strings.Join(dirs, ","), "", ""), "Expected error without parity shards")
+}
+
+func TestPushAndCloneTieredRemote(t *testing.T) {
+
+	metadataDir, _ := ioutil.TempDir("", "s3git-tiered-metadata-")
+	defer os.RemoveAll(metadataDir)
+	bulkDir, _ := ioutil.TempDir("", "s3git-tiered-bulk-")
+	defer os.RemoveAll(bulkDir)
+
+	repo2, path2 := pushAndClone(t, "tiered://file://" + metadataDir + ",file://" + bulkDir, func(commit string, hashes []string) {
+
+		// Commits are stored on the metadata tier and blobs on the bulk tier
+		metadata := file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: metadataDir})
+		bulk := file.MakeClient(config.RemoteObject{Type: config.REMOTE_FILE, FileDirectory: bulkDir})
+		exists, err := metadata.ExistsMany(append([]string{commit}, hashes...))
+		assert.Nil(t, err)
+		assert.Equal(t, append([]bool{true}, make([]bool, len(hashes))...), exists)
+		exists, err = bulk.ExistsMany(hashes)
+		assert.Nil(t, err)
+		for _, e := range exists {
+			assert.True(t, e)
+		}
+	}, RemoteOptionSetMaxMetadataSize(64*1024))
+	defer teardownRepo(path2)
+
+	assert.NotNil(t, repo2.RemoteAdd("single", "tiered://file://" + metadataDir, "", ""), "Expected error for a single tier")
+}
+
+func TestPullFromAlternate(t *testing.T) {
+
+	primaryDir, _ := ioutil.TempDir("", "s3git-primary-")
+	defer os.RemoveAll(primaryDir)
+	alternateDir, _ := ioutil.TempDir("", "s3git-alternate-")
+	defer os.RemoveAll(alternateDir)
+
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+	assert.Nil(t, repo.RemoteAdd("primary", "file://" + primaryDir, "", ""))
+	assert.Nil(t, repo.RemoteAdd("alternate", "file://" + alternateDir, "", ""))
+
+	hash, _, _ := repo.Add(strings.NewReader("hello s3git: alternate"))
+	repo.Commit("1st commit")
+
+	assert.Nil(t, repo.Push(false, func(total int64) {}, PushOptionSetRemote("primary")))
+	assert.Nil(t, repo.Push(false, func(total int64) {}, PushOptionSetRemote("alternate")))
+
+	path2, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path2)
+
+	repo2, err := Clone("file://" + primaryDir, path2)
+	assert.Nil(t, err)
+
+	// Blob is no longer available on the primary
+	os.RemoveAll(primaryDir)
+	os.Mkdir(primaryDir, 0755)
+
+	assert.Nil(t, repo2.AlternateAdd("alternate", "file://" + alternateDir, "", ""))
+	assert.NotNil(t, repo2.AlternateAdd("primary", "file://" + alternateDir, "", ""), "Expected error for name of existing remote")
+
+	alternates, _ := repo2.AlternatesShow()
+	assert.Equal(t, 1, len(alternates))
+	assert.Equal(t, "file://" + alternateDir, alternates[0].Endpoint)
+
+	r, err := repo2.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "hello s3git: alternate", string(output))
+
+	assert.Nil(t, repo2.AlternateRemove("alternate"))
+	alternates, _ = repo2.AlternatesShow()
+	assert.Equal(t, 0, len(alternates))
+}
+
+func TestCloneWithContentDefinedChunking(t *testing.T) {
+
+	remoteDir, _ := ioutil.TempDir("", "s3git-remote-")
+	defer os.RemoveAll(remoteDir)
+
+	path, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path)
+	repo, _ := InitRepository(path, InitOptionSetLeafSize(64*1024), InitOptionSetRollingHash(12, 1024))
+	assert.Nil(t, repo.RemoteAdd("primary", "file://" + remoteDir, "", ""))
+
+	content := strings.Repeat("content defined chunking ", 20000)
+	hash, _, _ := repo.Add(strings.NewReader(content))
+	repo.Commit("1st commit")
+	assert.Nil(t, repo.Push(false, func(total int64) {}))
+
+	// Clone (without content defined chunking) reads the leaves as they were cut
+	path2, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path2)
+
+	repo2, err := Clone("file://" + remoteDir, path2, CloneOptionSetLeafSize(64*1024))
+	assert.Nil(t, err)
+
+	r, err := repo2.Get(hash)
+	assert.Nil(t, err)
+	output, _ := ioutil.ReadAll(r)
+	assert.Equal(t, content, string(output))
+}
@@ -32,6 +32,10 @@ type Repository struct {
 type initOptions struct {
 	leafSize uint32
 	maxRepoSize uint64
+	rollingHashBits int
+	rollingHashMin int
+	compression string
+	encryptionKey string
 }
 
 func InitOptionSetLeafSize(leafSize uint32) func(optns *initOptions) {
@@ -46,6 +50,29 @@ func InitOptionSetMaxRepoSize(maxRepoSize uint64) func(optns *initOptions) {
 	}
 }
 
+// Use content defined chunking, cutting a leaf when the lower 'bits' of the rolling hash are all set
+// (with leaves at least 'min' bytes and at most the leaf size in length)
+func InitOptionSetRollingHash(bits, min int) func(optns *initOptions) {
+	return func(optns *initOptions) {
+		optns.rollingHashBits = bits
+		optns.rollingHashMin = min
+	}
+}
+
+// Compress leaves at rest and in transit, either "snappy" or "deflate" (hashes are computed over the uncompressed contents)
+func InitOptionSetCompression(compression string) func(optns *initOptions) {
+	return func(optns *initOptions) {
+		optns.compression = compression
+	}
+}
+
+// Encrypt objects on the remote back end with the given (hex encoded) key
+func InitOptionSetEncryptionKey(encryptionKey string) func(optns *initOptions) {
+	return func(optns *initOptions) {
+		optns.encryptionKey = encryptionKey
+	}
+}
+
 type InitOptions func(*initOptions)
 
 // Initialize a new repository
@@ -56,7 +83,17 @@ func InitRepository(path string, options ...InitOptions) (*Repository, error) {
 		op(optns)
 	}
 
-	config.SaveConfig(path, optns.leafSize, optns.maxRepoSize)
+	err := config.SaveConfig(path, optns.leafSize, optns.maxRepoSize, optns.rollingHashBits, optns.rollingHashMin, optns.compression)
+	if err != nil {
+		return nil, err
+	}
+
+	if optns.encryptionKey != "" {
+		err = config.SetEncryptionKey(optns.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return OpenRepository(path)
 }
@@ -144,7 +181,7 @@ func (repo Repository) Add(r io.Reader) (string, bool, error) {
 	return rootKeyStr, newBlob, err
 }
 
-// Get a stream from the repository
+// Get a stream from the repository (the stream is a Blob as well, see Open)
 func (repo Repository) Get(hash string) (io.Reader, error) {
 
 	cr := cas.MakeReader(hash)
@@ -152,23 +189,42 @@ func (repo Repository) Get(hash string) (io.Reader, error) {
 		return nil, errors.New("Failed to create cas reader")
 	}
 
-	r, w := io.Pipe()
+	return cr, nil
+}
 
-	go func(cr *cas.Reader, w io.WriteCloser) {
+// Blob allows random access to the contents of a blob
+type Blob interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	Size() (int64, error)
+}
 
-		defer w.Close()
+// Open a blob in the repository for random access. Get keeps returning an io.Reader so that
+// existing callers do not break, Open returns a Blob and the reason why a blob cannot be opened
+func (repo Repository) Open(hash string) (Blob, error) {
 
-		io.Copy(w, cr)
-	}(cr, w)
+	cr, err := cas.OpenReader(hash)
+	if err != nil {
+		return nil, err
+	}
 
-	return r, nil
+	return cr, nil
 }
 
 type Statistics struct {
-	Objects   uint64
-	TotalSize uint64
-	StageSize uint64
-	CacheSize uint64
+	Objects         uint64  // Number of blobs
+	TotalSize       uint64  // Logical size of all blobs (for which the size is known locally)
+	PhysicalSize    uint64  // Size of all unique leaves that are stored locally
+	DedupRatio      float64 // Ratio of logical size versus physical size
+	StageSize       uint64
+	CacheSize       uint64
+	Commits         uint64
+	Trees           uint64
+	Prefixes        uint64
+	Snapshots       uint64
+	HydratedBlobs   uint64 // Number of blobs with all leaves available locally
+	RemoteOnlyBlobs uint64 // Number of blobs with (some) leaves only available remotely
 }
 
 // Get statistics for the repository
@@ -189,5 +245,30 @@ func (repo Repository) Statistics() (*Statistics, error) {
 		return nil, err
 	}
 
-	return &Statistics{Objects: entries, TotalSize: 0, StageSize: stageSize, CacheSize: cacheSize}, nil
+	totalSize, hydrated, remoteOnly, err := kv.GetLevel1BlobsSizeStats()
+	if err != nil {
+		return nil, err
+	}
+
+	physicalSize, err := kv.GetLevel0PhysicalSize()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Statistics{Objects: entries, TotalSize: totalSize, PhysicalSize: physicalSize, StageSize: stageSize, CacheSize: cacheSize,
+		HydratedBlobs: hydrated, RemoteOnlyBlobs: remoteOnly}
+
+	if physicalSize > 0 {
+		stats.DedupRatio = float64(totalSize) / float64(physicalSize)
+	}
+
+	// Get number of objects for the other types
+	for objType, count := range map[string]*uint64{kv.COMMIT: &stats.Commits, kv.TREE: &stats.Trees, kv.PREFIX: &stats.Prefixes, kv.SNAPSHOT: &stats.Snapshots} {
+		*count, err = kv.GetLevel1Stats(objType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
 }
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"github.com/s3git/s3git-go/internal/core"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -39,6 +41,25 @@ func TestAddAndGet(t *testing.T) {
 	assert.Equal(t, "s3git", s, "Expected s3git")
 }
 
+func TestOpen(t *testing.T) {
+	repo, path := setupRepo()
+	defer teardownRepo(path)
+
+	hash, _, _ := repo.Add(strings.NewReader("hello s3git"))
+	blob, err := repo.Open(hash)
+	assert.Nil(t, err)
+	defer blob.Close()
+
+	p := make([]byte, 5)
+	_, err = blob.ReadAt(p, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, "s3git", string(p), "Expected s3git")
+
+	blob.Seek(6, io.SeekStart)
+	rest, _ := ioutil.ReadAll(blob)
+	assert.Equal(t, "s3git", string(rest), "Expected s3git")
+}
+
 func TestManyAdds(t *testing.T) {
 	repo, path := setupRepo()
 	defer teardownRepo(path)
@@ -55,6 +76,33 @@ func TestManyAdds(t *testing.T) {
 	assert.Equal(t, 100, len(to.S3gitAdded), "Expected 100 items in tree object")
 }
 
+func TestStatistics(t *testing.T) {
+	path, _ := ioutil.TempDir("", "s3git-test-")
+	defer teardownRepo(path)
+
+	repo, _ := InitRepository(path, InitOptionSetLeafSize(1024))
+
+	// Both blobs share the first leaf
+	repo.Add(strings.NewReader(strings.Repeat("s", 1024) + "3"))
+	repo.Add(strings.NewReader(strings.Repeat("s", 1024) + "git"))
+
+	hash, _, _ := repo.Commit("1st commit")
+	assert.NotEmpty(t, hash)
+
+	stats, err := repo.Statistics()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), stats.Objects, "Number of objects is not correct")
+	assert.Equal(t, uint64(1025+1027), stats.TotalSize, "Total size is not correct")
+	assert.Equal(t, uint64(2), stats.HydratedBlobs, "Number of hydrated blobs is not correct")
+	assert.Equal(t, uint64(0), stats.RemoteOnlyBlobs, "Number of remote only blobs is not correct")
+	assert.Equal(t, uint64(1), stats.Commits, "Number of commits is not correct")
+	assert.Equal(t, uint64(1), stats.Trees, "Number of trees is not correct")
+	assert.Equal(t, uint64(1), stats.Prefixes, "Number of prefixes is not correct")
+	assert.Equal(t, uint64(0), stats.Snapshots, "Number of snapshots is not correct")
+	assert.Equal(t, stats.StageSize+stats.CacheSize, stats.PhysicalSize, "Physical size is not correct")
+	assert.Equal(t, float64(stats.TotalSize)/float64(stats.PhysicalSize), stats.DedupRatio, "Deduplication ratio is not correct")
+}
+
 func TestList(t *testing.T) {
 
 }
@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"github.com/s3git/s3git-go/internal/kv"
 	"github.com/s3git/s3git-go/internal/cas"
+	"github.com/s3git/s3git-go/internal/config"
 	"github.com/s3git/s3git-go/internal/core"
 	"github.com/s3git/s3git-go/internal/backend"
 	"github.com/s3git/s3git-go/internal/backend/s3"
@@ -282,12 +283,19 @@ func getSnapshotFromCommit(commit string) (string, error) {
 
 	// Has snapshot not yet been pulled down to disk?
 	if len(leafHashes) == 0 {
-		client, err := backend.GetDefaultClient()
+		clients, err := backend.GetClientsInOrder()
 		if err != nil {
 			return "", err
 		}
 
-		err = pullSnapshotWithChildren(co.S3gitSnapshot, client)
+		// Try the remotes in order until the snapshot is pulled down
+		remotes := config.GetRemotesInOrder()
+		for i, client := range clients {
+			err = pullSnapshotWithChildren(co.S3gitSnapshot, client, remotes[i].Limits.GetConcurrency(pullSnapshotRoutines))
+			if err == nil {
+				break
+			}
+		}
 		if err != nil {
 			return "", err
 		}
@@ -296,15 +304,15 @@ func getSnapshotFromCommit(commit string) (string, error) {
 	return co.S3gitSnapshot, nil
 }
 
-func pullSnapshotWithChildren(hash string, client backend.Backend) error {
+const pullSnapshotRoutines = 100
 
-	const pullSnapshotRoutines = 100
+func pullSnapshotWithChildren(hash string, client backend.Backend, concurrency int) error {
 
 	var wg sync.WaitGroup
-	var msgs = make(chan string, pullSnapshotRoutines*2)
-	var results = make(chan error, pullSnapshotRoutines*2)
+	var msgs = make(chan string, concurrency*2)
+	var results = make(chan error, concurrency*2)
 
-	for i := 0; i < pullSnapshotRoutines; i++ {
+	for i := 0; i < concurrency; i++ {
 
 		go func() {
 			for hash := range msgs {
@@ -313,11 +321,18 @@ func pullSnapshotWithChildren(hash string, client backend.Backend) error {
 				// Now pull down snapshot object
 				snapshotName, snapshotBytes, err := fetchBlobTempFileAndContents(hash, client)
 				if err != nil {
-					//return err
+					results <- err
+					wg.Done()
+					continue
 				}
 				defer os.Remove(snapshotName)
 
 				so, err := core.GetSnapshotObjectFromString(string(snapshotBytes))
+				if err != nil {
+					results <- err
+					wg.Done()
+					continue
+				}
 
 				for _, entry := range so.S3gitEntries {
 					if entry.IsDirectory() {
@@ -330,7 +345,7 @@ func pullSnapshotWithChildren(hash string, client backend.Backend) error {
 				// Add snapshot object to cas
 				_, err = cas.StoreBlobInCache(snapshotName, kv.SNAPSHOT)
 				if err != nil {
-					//return err
+					results <- err
 				}
 
 				//fmt.Println("wg.Done for", hash)